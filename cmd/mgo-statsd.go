@@ -2,63 +2,68 @@ package main
 
 import (
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
-	"github.com/kr/pretty"
 	mgostatsd "github.com/scullxbones/mgo-statsd"
-	"gopkg.in/mgo.v2"
 )
 
 func main() {
 	config := mgostatsd.LoadConfig()
 
-	quit := make(chan struct{})
-	for i, server := range config.Mongo.Addresses {
-		session, err := mgostatsd.GetSession(config.Mongo, server)
-		if err != nil {
-			log.Printf("Error connecting to mongo %s: %v\n", server, err)
-			continue
+	// the Prometheus exporter and the health pages share a listener when given the same address
+	muxes := make(map[string]*http.ServeMux)
+	muxFor := func(listen string) *http.ServeMux {
+		if _, ok := muxes[listen]; !ok {
+			muxes[listen] = http.NewServeMux()
 		}
-		defer session.Close()
+		return muxes[listen]
+	}
+
+	var exporter *mgostatsd.PrometheusExporter
+	if config.Prometheus.Listen != "" {
+		exporter = mgostatsd.NewPrometheusExporter(config.Statsd)
+		muxFor(config.Prometheus.Listen).Handle(config.Prometheus.Path, exporter)
+	}
+	runner := mgostatsd.NewRunner(exporter)
+	if config.Health.Listen != "" {
+		mgostatsd.NewHealthHandler(runner, config.Health.ReadyIntervals).Register(muxFor(config.Health.Listen))
+	}
+	for listen, mux := range muxes {
+		go func(listen string, mux *http.ServeMux) {
+			log.Fatal(http.ListenAndServe(listen, mux))
+		}(listen, mux)
+	}
 
-		ticker := time.NewTicker(config.Interval)
-		go func(session *mgo.Session, server string, num int) {
-			for {
-				select {
-				case <-ticker.C:
-					if config.Verbose {
-						log.Printf("[%v] Starting stats for address %v \n", num, server)
-					}
+	if err := runner.Apply(config); err != nil {
+		log.Fatal(err)
+	}
 
-					status, err := mgostatsd.GetServerStatus(session)
-					if err != nil {
-						log.Printf("Error running 'serverStatus' command: %v\n", err)
-						continue
-					}
-					if config.Verbose {
-						log.Println(pretty.Sprintf("Mongo ServerStatus: \n%v\n", status))
-					}
+	quit := make(chan struct{})
+	go runner.ReportRuntime(quit, config.Interval)
 
-					err = mgostatsd.PushStats(config.Statsd, status, config.Verbose)
-					if err != nil {
-						log.Printf("[%v] ERROR: %v\n", num, err)
-					}
-					if config.Verbose {
-						log.Printf("[%v] Done pushing stats for address %v\n", num, server)
-					}
-				case <-quit:
-					ticker.Stop()
-					return
-				}
-			}
-		}(session, server, i)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	for sig := range ch {
+		log.Printf("Received signal [%s]", sig.String())
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloaded, err := mgostatsd.ReloadConfig()
+		if err == nil {
+			err = runner.Apply(reloaded)
+		}
+		if err != nil {
+			log.Printf("Error reloading configuration, keeping the current one: %v\n", err)
+			continue
+		}
+		if reloaded.Prometheus != config.Prometheus || reloaded.Health != config.Health {
+			log.Println("Prometheus and health listener settings only take effect after a restart")
+		}
+		log.Println("Configuration reloaded")
 	}
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	sig := <-ch
-	log.Printf("Received signal [%s]", sig.String())
 	close(quit)
+	runner.Stop()
 }
@@ -0,0 +1,66 @@
+package mgostatsd
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/mgo.v2"
+)
+
+// Collector gathers a group of metrics from a session alongside GetServerStatus.
+// A Collector is created per polled address and may keep state between calls.
+type Collector interface {
+	Collect(session *mgo.Session, sink Sink) error
+}
+
+// collectorFactories maps the names accepted by -collector to their constructors
+var collectorFactories = map[string]func(Config) (Collector, error){
+	"replset":  func(Config) (Collector, error) { return &ReplSetCollector{}, nil },
+	"oplog":    func(Config) (Collector, error) { return &OplogCollector{}, nil },
+	"dbstats":  newDbStatsCollector,
+	"sharding": func(Config) (Collector, error) { return &ShardingCollector{}, nil },
+	"connpool": func(Config) (Collector, error) { return &ConnPoolCollector{}, nil },
+}
+
+// CollectorNames returns the names of every available collector
+func CollectorNames() []string {
+	names := make([]string, 0, len(collectorFactories))
+	for name := range collectorFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCollectors creates one instance of every collector enabled in config
+func NewCollectors(config Config) ([]Collector, error) {
+	collectors := make([]Collector, 0, len(config.Collectors))
+	for _, name := range config.Collectors {
+		factory, ok := collectorFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown collector %q, expected one of %v", name, CollectorNames())
+		}
+		collector, err := factory(config)
+		if err != nil {
+			return nil, fmt.Errorf("Collector %s: %v", name, err)
+		}
+		collectors = append(collectors, collector)
+	}
+	return collectors, nil
+}
+
+// isCommandUnsupported reports whether err means the server can't run a command in its current role,
+// such as replSetGetStatus on a standalone, which collectors treat as nothing to collect
+func isCommandUnsupported(err error) bool {
+	qerr, ok := err.(*mgo.QueryError)
+	if !ok {
+		return false
+	}
+	switch qerr.Code {
+	case 59, // CommandNotFound
+		76, // NoReplicationEnabled
+		94: // NotYetInitialized
+		return true
+	}
+	return false
+}
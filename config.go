@@ -3,9 +3,10 @@ package mgostatsd
 import (
 	"flag"
 	"fmt"
+	"log"
+	"os"
+	str "strings"
 	"time"
-
-	"github.com/vharitonsky/iniflags"
 )
 
 type strings []string
@@ -16,22 +17,120 @@ type Mongo struct {
 	User      string
 	Pass      string
 	AuthDb    string
+
+	Mechanism      string
+	ReplicaSet     string
+	TLS            TLS
+	Timeout        time.Duration
+	ReadPreference string // only validated, every address is polled whatever its state
+}
+
+/* TLS portion of the mongo configuration, applied to every address */
+type TLS struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// Validate checks the options that can't work together
+func (m Mongo) Validate() error {
+	if m.Mechanism == MechanismX509 {
+		if !m.TLS.Enabled {
+			return fmt.Errorf("%s authentication requires TLS", MechanismX509)
+		}
+		if m.TLS.CertFile == "" {
+			return fmt.Errorf("%s authentication requires a client certificate", MechanismX509)
+		}
+	}
+	return nil
 }
 
 /* Statsd portion of configuration */
 type Statsd struct {
-	Host    string
-	Port    int
-	Env     string
-	Cluster string
+	Enabled   bool
+	Host      string
+	Port      int
+	Env       string
+	Cluster   string
+	DogStatsd bool
+	Prefix    string
+	Tags      []Tag
+
+	// SelfPrefix names the metrics about mgo-statsd itself, they are not sent when it is empty
+	SelfPrefix string
+
+	FlushInterval time.Duration
+	MaxPacketSize int
+}
+
+/* Prometheus portion of configuration, the exporter is disabled when Listen is empty */
+type Prometheus struct {
+	Listen string
+	Path   string
+}
+
+/* Health portion of configuration, the health and status pages are disabled when Listen is empty */
+type Health struct {
+	Listen         string
+	ReadyIntervals int
+}
+
+/* Storage portion of configuration, used by the dbstats collector */
+type Storage struct {
+	Databases        Filter
+	Collections      bool
+	CollectionFilter Filter
+}
+
+/* Raw portion of configuration, flattening the whole serverStatus instead of the known fields */
+type Raw struct {
+	Enabled bool
+	Filter  Filter
+}
+
+/* Discovery portion of configuration, polling every replica set member found from the mongo addresses */
+type Discovery struct {
+	Enabled  bool
+	Interval time.Duration
 }
 
 /* Config contains full configuration for utility */
 type Config struct {
-	Verbose  bool
-	Interval time.Duration
-	Mongo    Mongo
-	Statsd   Statsd
+	Name       string
+	Verbose    bool
+	Interval   time.Duration
+	Reconnect  Backoff
+	Counters   string
+	Collectors []string
+	Mongo      Mongo
+	Discovery  Discovery
+	Statsd     Statsd
+	Prometheus Prometheus
+	Health     Health
+	Storage    Storage
+	Raw        Raw
+
+	// LatencyHistograms asks serverStatus for the opLatencies histograms
+	LatencyHistograms bool
+
+	// Targets holds the configuration of every target of the config file, see TargetConfigs
+	Targets []Config
+}
+
+// ParseTags parses key:value pairs into tags
+func ParseTags(pairs []string) ([]Tag, error) {
+	tags := make([]Tag, 0, len(pairs))
+	for _, pair := range pairs {
+		kv := str.SplitN(pair, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid tag %q, expected key:value", pair)
+		}
+		tags = append(tags, Tag{Key: kv[0], Value: kv[1]})
+	}
+	return tags, nil
 }
 
 func (s *strings) String() string {
@@ -43,43 +142,231 @@ func (s *strings) Set(value string) error {
 	return nil
 }
 
-var mongoAddresses strings
+// reloadConfig builds the configuration from the flags and the config file, set by LoadConfig
+var reloadConfig func() (Config, error)
 
 /* LoadConfig loads the configuration from command-line options */
 func LoadConfig() Config {
+	load := newConfigLoader(flag.CommandLine, os.Args[1:])
+	cfg, err := load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if f := flag.Lookup("dumpflags"); f != nil && f.Value.String() == "true" {
+		dumpFlags(flag.CommandLine)
+		os.Exit(0)
+	}
+	reloadConfig = load
+	return cfg
+}
+
+// newConfigLoader defines the flags on flags and parses args. The returned function builds the
+// configuration from them, the ini file of -config and the YAML file of -config_yaml, re-reading
+// both files on every call. Flags given in args take precedence over the YAML file, which takes
+// precedence over the ini file.
+func newConfigLoader(flags *flag.FlagSet, args []string) func() (Config, error) {
 	var (
-		verbose       = flag.Bool("verbose", false, "Verbose logging")
-		mongoUser     = flag.String("mongo_user", "", "MongoDB User")
-		mongoPass     = flag.String("mongo_pass", "", "MongoDB Password")
-		mongoAuthDb   = flag.String("mongo_auth_db", "admin", "MongoDB Authentication DB")
-		statsdHost    = flag.String("statsd_host", "localhost", "StatsD Host")
-		statsdPort    = flag.Int("statsd_port", 8125, "StatsD Port")
-		statsdEnv     = flag.String("statsd_env", "dev", "StatsD metric environment prefix")
-		statsdCluster = flag.String("statsd_cluster", "unknown", "StatsD metric cluster prefix")
-		interval      = flag.Duration("interval", 5*time.Second, "Polling interval")
+		mongoAddresses   strings
+		collectors       strings
+		dbstatsInclude   strings
+		dbstatsExclude   strings
+		collstatsInclude strings
+		collstatsExclude strings
+		rawAllow         strings
+		rawDeny          strings
+		statsdTags       strings
+	)
+	var (
+		verbose       = flags.Bool("verbose", false, "Verbose logging")
+		mongoUser     = flags.String("mongo_user", "", "MongoDB User")
+		mongoPass     = flags.String("mongo_pass", "", "MongoDB Password")
+		mongoAuthDb   = flags.String("mongo_auth_db", "admin", "MongoDB Authentication DB")
+		mongoMech     = flags.String("mongo_auth_mechanism", "", "MongoDB authentication mechanism, e.g. SCRAM-SHA-1 or MONGODB-X509")
+		mongoTLS      = flags.Bool("mongo_tls", false, "Connect to MongoDB over TLS")
+		mongoCAFile   = flags.String("mongo_tls_ca_file", "", "PEM file of the CAs to verify MongoDB server certificates with (default system roots)")
+		mongoCertFile = flags.String("mongo_tls_cert_file", "", "PEM file of the client certificate, required for MONGODB-X509")
+		mongoKeyFile  = flags.String("mongo_tls_key_file", "", "PEM file of the client certificate key (default mongo_tls_cert_file)")
+		mongoTLSName  = flags.String("mongo_tls_server_name", "", "Server name to verify MongoDB server certificates against (default the address host)")
+		mongoInsecure = flags.Bool("mongo_tls_insecure", false, "Skip verification of MongoDB server certificates")
+		mongoURI      = flags.String("mongo_uri", "", "MongoDB connection string (mongodb:// or mongodb+srv://), replaces the other mongo_ options")
+		statsdEnabled = flags.Bool("statsd_enabled", true, "Push metrics to StatsD")
+		statsdHost    = flags.String("statsd_host", "localhost", "StatsD Host")
+		statsdPort    = flags.Int("statsd_port", 8125, "StatsD Port")
+		statsdEnv     = flags.String("statsd_env", "dev", "StatsD metric environment prefix")
+		statsdCluster = flags.String("statsd_cluster", "unknown", "StatsD metric cluster prefix")
+		dogStatsd     = flags.Bool("statsd_dogstatsd", false, "Send env, cluster, host, replset, state and version as DogStatsD tags instead of in the metric prefix")
+		statsdFlush   = flags.Duration("statsd_flush_interval", 300*time.Millisecond, "Maximum time StatsD metrics are buffered before being sent")
+		statsdPacket  = flags.Int("statsd_max_packet", 1432, "Maximum size in bytes of a StatsD packet, use 512 when sending over the internet")
+		statsdPrefix  = flags.String("statsd_prefix", "mongodb", "StatsD metric prefix in DogStatsD mode")
+		statsdSelf    = flags.String("statsd_self_prefix", "mgo_statsd", "StatsD prefix of the metrics about mgo-statsd itself, empty to disable them")
+		interval      = flags.Duration("interval", 5*time.Second, "Polling interval")
+		counters      = flags.String("counters", CountersGauge, "How to emit cumulative counters: gauge (raw value, rates for asserts, network and created pool connections), delta (StatsD counter of the increase per interval) or rate (increase per second)")
+		reconnectMin  = flags.Duration("reconnect_min", DefaultBackoff.Min, "Initial delay before redialing an unreachable mongo address")
+		reconnectMax  = flags.Duration("reconnect_max", DefaultBackoff.Max, "Maximum delay between redials of an unreachable mongo address")
+		promListen    = flags.String("prometheus_listen", "", "Address to serve Prometheus metrics on, e.g. :9216 (disabled when empty)")
+		promPath      = flags.String("prometheus_path", "/metrics", "HTTP path of the Prometheus metrics")
+		healthListen  = flags.String("health_listen", "", "Address to serve /healthz, /readyz and /status on, e.g. :8080 (disabled when empty)")
+		readyInterval = flags.Int("ready_intervals", 3, "Polling intervals without any successful poll after which /readyz fails")
+		histograms    = flags.Bool("latency_histograms", false, "Request the opLatencies histograms from serverStatus and emit their buckets as counters")
+		rawStatus     = flags.Bool("raw_status", false, "Emit every numeric serverStatus field, named by its serverStatus path, instead of the known fields")
+		collstats     = flags.Bool("collstats", false, "Also emit collStats for every collection when the dbstats collector is enabled")
+		discover      = flags.Bool("discover", false, "Treat the mongo addresses as seeds and poll every member of their replica sets")
+		discoverEvery = flags.Duration("discover_interval", time.Minute, "How often to re-check replica set membership with -discover")
+		configYAML    = flags.String("config_yaml", "", "YAML config file setting flags by name and defining targets with their own settings")
 	)
 
-	flag.Var(&mongoAddresses, "mongo_address", "List of mongo addresses in host:port format")
-	iniflags.Parse()
-	if len(mongoAddresses) == 0 {
-		mongoAddresses = append(mongoAddresses, "localhost:27017")
-	}
-	cfg := Config{
-		Verbose:  *verbose,
-		Interval: *interval,
-		Mongo: Mongo{
+	flags.Var(&mongoAddresses, "mongo_address", "List of mongo addresses in host:port format")
+	flags.Var(&collectors, "collector", fmt.Sprintf("Additional collectors to run alongside serverStatus, one of %v", CollectorNames()))
+	flags.Var(&dbstatsInclude, "dbstats_include", "Glob of database names to emit dbStats for (default all)")
+	flags.Var(&dbstatsExclude, "dbstats_exclude", "Glob of database names to skip")
+	flags.Var(&collstatsInclude, "collstats_include", "Glob of db.collection names to emit collStats for (default all)")
+	flags.Var(&collstatsExclude, "collstats_exclude", "Glob of db.collection names to skip")
+	flags.Var(&rawAllow, "raw_allow", "Glob, or regular expression prefixed with re:, of serverStatus paths to emit with -raw_status (default all)")
+	flags.Var(&rawDeny, "raw_deny", "Glob, or regular expression prefixed with re:, of serverStatus paths to skip with -raw_status")
+	flags.Var(&statsdTags, "statsd_tag", "Static DogStatsD tag in key:value format added to every metric")
+	flags.Parse(args)
+	// flags given on the command line take precedence over the config files
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	return func() (Config, error) {
+		iniPath := flagValue(flags, "config")
+		if iniPath != "" || *configYAML != "" {
+			// start over from the defaults, so settings removed from a file are reset on reload
+			flags.VisitAll(func(f *flag.Flag) {
+				if explicit[f.Name] {
+					return
+				}
+				if s, ok := f.Value.(*strings); ok {
+					*s = nil
+					return
+				}
+				f.Value.Set(f.DefValue)
+			})
+		}
+		if iniPath != "" {
+			if err := readIniFile(flags, iniPath, explicit); err != nil {
+				return Config{}, err
+			}
+		}
+		var file *configFile
+		if *configYAML != "" {
+			var err error
+			file, err = readConfigFile(*configYAML)
+			if err != nil {
+				return Config{}, err
+			}
+			if err := file.setFlags(flags, explicit); err != nil {
+				return Config{}, err
+			}
+		}
+		mongo := Mongo{
 			Addresses: mongoAddresses,
 			User:      *mongoUser,
 			Pass:      *mongoPass,
 			AuthDb:    *mongoAuthDb,
-		},
-		Statsd: Statsd{
-			Host:    *statsdHost,
-			Port:    *statsdPort,
-			Env:     *statsdEnv,
-			Cluster: *statsdCluster,
-		},
+		}
+		if *mongoURI != "" {
+			if len(mongoAddresses) > 0 {
+				return Config{}, fmt.Errorf("-mongo_uri and -mongo_address can't be combined")
+			}
+			var err error
+			mongo, err = ParseURI(*mongoURI)
+			if err != nil {
+				return Config{}, err
+			}
+		}
+		// TLS flags extend whatever the URI set
+		if *mongoMech != "" {
+			mongo.Mechanism = *mongoMech
+		}
+		mongo.TLS.Enabled = mongo.TLS.Enabled || *mongoTLS
+		mongo.TLS.InsecureSkipVerify = mongo.TLS.InsecureSkipVerify || *mongoInsecure
+		for dst, src := range map[*string]string{
+			&mongo.TLS.CAFile:     *mongoCAFile,
+			&mongo.TLS.CertFile:   *mongoCertFile,
+			&mongo.TLS.KeyFile:    *mongoKeyFile,
+			&mongo.TLS.ServerName: *mongoTLSName,
+		} {
+			if src != "" {
+				*dst = src
+			}
+		}
+		if err := mongo.Validate(); err != nil {
+			return Config{}, err
+		}
+		if len(mongo.Addresses) == 0 {
+			mongo.Addresses = append(mongo.Addresses, "localhost:27017")
+		}
+		tags, err := ParseTags(statsdTags)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg := Config{
+			Verbose:    *verbose,
+			Interval:   *interval,
+			Counters:   *counters,
+			Collectors: collectors,
+			Reconnect: Backoff{
+				Min:    *reconnectMin,
+				Max:    *reconnectMax,
+				Factor: DefaultBackoff.Factor,
+				Jitter: DefaultBackoff.Jitter,
+			},
+			Mongo: mongo,
+			Discovery: Discovery{
+				Enabled:  *discover,
+				Interval: *discoverEvery,
+			},
+			Statsd: Statsd{
+				Enabled:   *statsdEnabled,
+				Host:      *statsdHost,
+				Port:      *statsdPort,
+				Env:       *statsdEnv,
+				Cluster:   *statsdCluster,
+				DogStatsd: *dogStatsd,
+				Prefix:    *statsdPrefix,
+				Tags:      tags,
+
+				SelfPrefix: *statsdSelf,
+
+				FlushInterval: *statsdFlush,
+				MaxPacketSize: *statsdPacket,
+			},
+			Prometheus: Prometheus{
+				Listen: *promListen,
+				Path:   *promPath,
+			},
+			Health: Health{
+				Listen:         *healthListen,
+				ReadyIntervals: *readyInterval,
+			},
+			Storage: Storage{
+				Databases:        Filter{Include: dbstatsInclude, Exclude: dbstatsExclude},
+				Collections:      *collstats,
+				CollectionFilter: Filter{Include: collstatsInclude, Exclude: collstatsExclude},
+			},
+			Raw: Raw{
+				Enabled: *rawStatus,
+				Filter:  Filter{Include: rawAllow, Exclude: rawDeny},
+			},
+			LatencyHistograms: *histograms,
+		}
+		if file != nil {
+			cfg.Targets, err = file.targetConfigs(cfg)
+			if err != nil {
+				return Config{}, err
+			}
+		}
+
+		return cfg, nil
 	}
+}
 
-	return cfg
+/* ReloadConfig re-reads the config files given with -config and -config_yaml, command-line options keep their values */
+func ReloadConfig() (Config, error) {
+	if reloadConfig == nil {
+		return Config{}, fmt.Errorf("Configuration not loaded yet")
+	}
+	return reloadConfig()
 }
@@ -0,0 +1,286 @@
+package mgostatsd
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	str "strings"
+	"time"
+
+	"github.com/vharitonsky/iniflags"
+	"gopkg.in/yaml.v2"
+)
+
+// configFile is the YAML configuration file. Top level keys are flag names and set the flags not
+// given on the command line, targets override those settings per group of addresses.
+type configFile struct {
+	Flags   map[string]interface{} `yaml:",inline"`
+	Targets []targetFile           `yaml:"targets"`
+}
+
+// targetFile is one entry of targets, unset fields keep the global settings
+type targetFile struct {
+	Name       string        `yaml:"name"`
+	URI        string        `yaml:"uri"`
+	Addresses  []string      `yaml:"addresses"`
+	User       string        `yaml:"user"`
+	Pass       string        `yaml:"pass"`
+	AuthDb     string        `yaml:"auth_db"`
+	Mechanism  string        `yaml:"auth_mechanism"`
+	ReplicaSet string        `yaml:"replica_set"`
+	TLS        *tlsFile      `yaml:"tls"`
+	Interval   time.Duration `yaml:"interval"`
+	Collectors *[]string     `yaml:"collectors"`
+	Counters   string        `yaml:"counters"`
+	Discover   *bool         `yaml:"discover"`
+	Cluster    string        `yaml:"cluster"`
+	Statsd     *statsdFile   `yaml:"statsd"`
+}
+
+type tlsFile struct {
+	Enabled    *bool  `yaml:"enabled"`
+	CAFile     string `yaml:"ca_file"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ServerName string `yaml:"server_name"`
+	Insecure   *bool  `yaml:"insecure"`
+}
+
+type statsdFile struct {
+	Enabled   *bool             `yaml:"enabled"`
+	Host      string            `yaml:"host"`
+	Port      int               `yaml:"port"`
+	Env       string            `yaml:"env"`
+	DogStatsd *bool             `yaml:"dogstatsd"`
+	Prefix    string            `yaml:"prefix"`
+	Tags      map[string]string `yaml:"tags"`
+}
+
+// flagValue returns the value of the named flag, or "" when flags doesn't define it
+func flagValue(flags *flag.FlagSet, name string) string {
+	f := flags.Lookup(name)
+	if f == nil {
+		return ""
+	}
+	return f.Value.String()
+}
+
+// readIniFile sets the flags listed in the ini file of -config, skipping the ones in explicit.
+// A relative path is relative to the executable, as with iniflags. It is read here rather than
+// by iniflags.Parse, whose SIGHUP handler would set flags concurrently with a reload.
+func readIniFile(flags *flag.FlagSet, path string, explicit map[string]bool) error {
+	if !filepath.IsAbs(path) && !str.HasPrefix(path, "./") && !str.Contains(path, "://") {
+		path = filepath.Join(filepath.Dir(os.Args[0]), path)
+	}
+	args, ok := iniflags.ReadIniFile(path)
+	if !ok {
+		if flagValue(flags, "allowMissingConfig") == "true" {
+			return nil
+		}
+		return fmt.Errorf("Invalid config file %s", path)
+	}
+	for _, arg := range args {
+		if flags.Lookup(arg.Key) == nil {
+			if flagValue(flags, "allowUnknownFlags") == "true" {
+				continue
+			}
+			return fmt.Errorf("Unknown setting %q in config file %s", arg.Key, path)
+		}
+		if explicit[arg.Key] {
+			continue
+		}
+		if err := flags.Set(arg.Key, arg.Value); err != nil {
+			return fmt.Errorf("Invalid value for %q in config file %s: %v", arg.Key, path, err)
+		}
+	}
+	return nil
+}
+
+// dumpFlags prints the value of every flag in ini syntax, for -dumpflags
+func dumpFlags(flags *flag.FlagSet) {
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" && f.Name != "dumpflags" {
+			fmt.Printf("%s = %q  # %s\n", f.Name, f.Value.String(), f.Usage)
+		}
+	})
+}
+
+// readConfigFile parses the YAML configuration file at path
+func readConfigFile(path string) (*configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file configFile
+	err = yaml.UnmarshalStrict(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+	return &file, nil
+}
+
+// setFlags sets the flags named by the top level keys of the file, skipping the ones in explicit.
+// Lists set a repeatable flag once per element.
+func (f *configFile) setFlags(flags *flag.FlagSet, explicit map[string]bool) error {
+	names := make([]string, 0, len(f.Flags))
+	for name := range f.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("Unknown setting %q in config file", name)
+		}
+		if explicit[name] {
+			continue
+		}
+		values, ok := f.Flags[name].([]interface{})
+		if !ok {
+			values = []interface{}{f.Flags[name]}
+		}
+		for _, value := range values {
+			switch value.(type) {
+			case map[interface{}]interface{}, []interface{}:
+				return fmt.Errorf("Invalid value for %q in config file, expected a scalar or a list", name)
+			}
+			if err := flags.Set(name, fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("Invalid value for %q in config file: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// apply returns a copy of global with the settings of the target overridden
+func (t targetFile) apply(global Config) (Config, error) {
+	config := global
+	config.Name = t.Name
+	config.Targets = nil
+
+	mongo := global.Mongo
+	if t.URI != "" {
+		if len(t.Addresses) > 0 {
+			return config, fmt.Errorf("uri and addresses can't be combined")
+		}
+		var err error
+		mongo, err = ParseURI(t.URI)
+		if err != nil {
+			return config, err
+		}
+	}
+	if len(t.Addresses) > 0 {
+		mongo.Addresses = t.Addresses
+	}
+	for dst, src := range map[*string]string{
+		&mongo.User:       t.User,
+		&mongo.Pass:       t.Pass,
+		&mongo.AuthDb:     t.AuthDb,
+		&mongo.Mechanism:  t.Mechanism,
+		&mongo.ReplicaSet: t.ReplicaSet,
+	} {
+		if src != "" {
+			*dst = src
+		}
+	}
+	if t.TLS != nil {
+		if t.TLS.Enabled != nil {
+			mongo.TLS.Enabled = *t.TLS.Enabled
+		}
+		if t.TLS.Insecure != nil {
+			mongo.TLS.InsecureSkipVerify = *t.TLS.Insecure
+		}
+		for dst, src := range map[*string]string{
+			&mongo.TLS.CAFile:     t.TLS.CAFile,
+			&mongo.TLS.CertFile:   t.TLS.CertFile,
+			&mongo.TLS.KeyFile:    t.TLS.KeyFile,
+			&mongo.TLS.ServerName: t.TLS.ServerName,
+		} {
+			if src != "" {
+				*dst = src
+			}
+		}
+	}
+	if err := mongo.Validate(); err != nil {
+		return config, err
+	}
+	config.Mongo = mongo
+
+	if t.Interval > 0 {
+		config.Interval = t.Interval
+	}
+	if t.Collectors != nil {
+		config.Collectors = *t.Collectors
+	}
+	if t.Counters != "" {
+		config.Counters = t.Counters
+	}
+	if t.Discover != nil {
+		config.Discovery.Enabled = *t.Discover
+	}
+	if t.Cluster != "" {
+		config.Statsd.Cluster = t.Cluster
+	}
+	if t.Statsd != nil {
+		if t.Statsd.Enabled != nil {
+			config.Statsd.Enabled = *t.Statsd.Enabled
+		}
+		if t.Statsd.DogStatsd != nil {
+			config.Statsd.DogStatsd = *t.Statsd.DogStatsd
+		}
+		if t.Statsd.Port != 0 {
+			config.Statsd.Port = t.Statsd.Port
+		}
+		for dst, src := range map[*string]string{
+			&config.Statsd.Host:   t.Statsd.Host,
+			&config.Statsd.Env:    t.Statsd.Env,
+			&config.Statsd.Prefix: t.Statsd.Prefix,
+		} {
+			if src != "" {
+				*dst = src
+			}
+		}
+		if len(t.Statsd.Tags) > 0 {
+			keys := make([]string, 0, len(t.Statsd.Tags))
+			for key := range t.Statsd.Tags {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			tags := append([]Tag(nil), global.Statsd.Tags...)
+			for _, key := range keys {
+				tags = append(tags, Tag{Key: key, Value: t.Statsd.Tags[key]})
+			}
+			config.Statsd.Tags = tags
+		}
+	}
+
+	return config, nil
+}
+
+// targetConfigs applies every target of the file to global
+func (f *configFile) targetConfigs(global Config) ([]Config, error) {
+	targets := make([]Config, 0, len(f.Targets))
+	for i, target := range f.Targets {
+		if target.Name == "" {
+			target.Name = fmt.Sprintf("target-%d", i+1)
+		}
+		config, err := target.apply(global)
+		if err != nil {
+			return nil, fmt.Errorf("Target %s: %v", target.Name, err)
+		}
+		targets = append(targets, config)
+	}
+	return targets, nil
+}
+
+// TargetConfigs returns the configuration of every target in the config file, or config itself
+// when the file defines no targets
+func (c Config) TargetConfigs() []Config {
+	if len(c.Targets) == 0 {
+		return []Config{c}
+	}
+	return c.Targets
+}
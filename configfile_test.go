@@ -0,0 +1,209 @@
+package mgostatsd
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testConfigFile = `
+interval: 10s
+statsd_host: statsd.internal
+collector: [replset, oplog]
+targets:
+  - name: orders
+    addresses: [orders1:27017, orders2:27017]
+    user: orders-stats
+    pass: secret
+    cluster: orders
+    interval: 5s
+    tls:
+      enabled: true
+      ca_file: /etc/ssl/orders-ca.pem
+    statsd:
+      host: statsd.orders.internal
+      tags:
+        team: checkout
+  - uri: mongodb://reporting1,reporting2/?replicaSet=rpt
+    collectors: []
+    discover: true
+`
+
+func writeConfigFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "mgo-statsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "mgo-statsd.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestConfigFileFlags(t *testing.T) {
+	path, cleanup := writeConfigFile(t, testConfigFile)
+	defer cleanup()
+
+	file, err := readConfigFile(path)
+	if err != nil {
+		t.Fatalf("readConfigFile failed: %v", err)
+	}
+
+	var collectors strings
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	interval := flags.Duration("interval", 5*time.Second, "")
+	host := flags.String("statsd_host", "localhost", "")
+	flags.Var(&collectors, "collector", "")
+	if err := flags.Parse([]string{"-interval", "1m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := file.setFlags(flags, map[string]bool{"interval": true}); err != nil {
+		t.Fatalf("setFlags failed: %v", err)
+	}
+	if *interval != time.Minute {
+		t.Errorf("expected the command line interval to win, got %v", *interval)
+	}
+	if *host != "statsd.internal" {
+		t.Errorf("expected statsd_host from the file, got %q", *host)
+	}
+	if !reflect.DeepEqual([]string(collectors), []string{"replset", "oplog"}) {
+		t.Errorf("unexpected collectors %v", collectors)
+	}
+
+	file.Flags["statsd_hostname"] = "typo"
+	if err := file.setFlags(flags, nil); err == nil {
+		t.Error("expected an error for an unknown setting")
+	}
+}
+
+func TestConfigFileTargets(t *testing.T) {
+	path, cleanup := writeConfigFile(t, testConfigFile)
+	defer cleanup()
+
+	file, err := readConfigFile(path)
+	if err != nil {
+		t.Fatalf("readConfigFile failed: %v", err)
+	}
+	global := Config{
+		Interval:   10 * time.Second,
+		Collectors: []string{"replset"},
+		Mongo:      Mongo{Addresses: []string{"localhost:27017"}, User: "stats", AuthDb: "admin"},
+		Statsd:     Statsd{Enabled: true, Host: "statsd.internal", Port: 8125, Env: "prod", Cluster: "main"},
+	}
+	targets, err := file.targetConfigs(global)
+	if err != nil {
+		t.Fatalf("targetConfigs failed: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(targets))
+	}
+
+	orders := targets[0]
+	if orders.Name != "orders" || orders.Interval != 5*time.Second || orders.Statsd.Cluster != "orders" {
+		t.Errorf("unexpected orders target %+v", orders)
+	}
+	expectedMongo := Mongo{
+		Addresses: []string{"orders1:27017", "orders2:27017"},
+		User:      "orders-stats",
+		Pass:      "secret",
+		AuthDb:    "admin",
+		TLS:       TLS{Enabled: true, CAFile: "/etc/ssl/orders-ca.pem"},
+	}
+	if !reflect.DeepEqual(orders.Mongo, expectedMongo) {
+		t.Errorf("expected %+v, got %+v", expectedMongo, orders.Mongo)
+	}
+	if orders.Statsd.Host != "statsd.orders.internal" || orders.Statsd.Port != 8125 ||
+		!reflect.DeepEqual(orders.Statsd.Tags, []Tag{{Key: "team", Value: "checkout"}}) {
+		t.Errorf("unexpected orders statsd config %+v", orders.Statsd)
+	}
+
+	reporting := targets[1]
+	if reporting.Name != "target-2" || len(reporting.Collectors) != 0 || !reporting.Discovery.Enabled {
+		t.Errorf("unexpected reporting target %+v", reporting)
+	}
+	if reporting.Mongo.ReplicaSet != "rpt" || len(reporting.Mongo.Addresses) != 2 || reporting.Statsd.Cluster != "main" {
+		t.Errorf("unexpected reporting target %+v", reporting)
+	}
+	if global.Collectors[0] != "replset" || global.Mongo.User != "stats" {
+		t.Error("expected the global config to be left unchanged")
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	for _, content := range []string{
+		"targets:\n  - addresses: [a:27017]\n    passwd: typo\n",
+		"targets:\n  - uri: mongodb://a\n    addresses: [b:27017]\n",
+		"targets:\n  - auth_mechanism: MONGODB-X509\n",
+	} {
+		path, cleanup := writeConfigFile(t, content)
+		file, err := readConfigFile(path)
+		if err == nil {
+			_, err = file.targetConfigs(Config{Mongo: Mongo{Addresses: []string{"localhost:27017"}}})
+		}
+		if err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+		cleanup()
+	}
+}
+
+func TestConfigLoaderReload(t *testing.T) {
+	iniPath, cleanupIni := writeConfigFile(t, "interval = 10s\nstatsd_env = ini\nstatsd_host = ini.internal\n")
+	defer cleanupIni()
+	yamlPath, cleanupYAML := writeConfigFile(t, "statsd_env: yaml\n")
+	defer cleanupYAML()
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("config", "", "") // defined by iniflags on the command line flags
+	load := newConfigLoader(flags, []string{"-config", iniPath, "-config_yaml", yamlPath, "-statsd_host", "cli.internal"})
+
+	config, err := load()
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if config.Interval != 10*time.Second {
+		t.Errorf("expected the interval from the ini file, got %v", config.Interval)
+	}
+	if config.Statsd.Env != "yaml" {
+		t.Errorf("expected the YAML file to override the ini file, got %q", config.Statsd.Env)
+	}
+	if config.Statsd.Host != "cli.internal" {
+		t.Errorf("expected the command line to override the ini file, got %q", config.Statsd.Host)
+	}
+
+	if err := ioutil.WriteFile(iniPath, []byte("interval = 20s\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(yamlPath, []byte("mongo_address: [db1:27017, db2:27017]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err = load()
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if config.Interval != 20*time.Second {
+		t.Errorf("expected the reloaded interval, got %v", config.Interval)
+	}
+	if config.Statsd.Env != "dev" {
+		t.Errorf("expected statsd_env removed from both files to be reset, got %q", config.Statsd.Env)
+	}
+	if !reflect.DeepEqual(config.Mongo.Addresses, []string{"db1:27017", "db2:27017"}) {
+		t.Errorf("unexpected addresses %v", config.Mongo.Addresses)
+	}
+	if config.Statsd.Host != "cli.internal" {
+		t.Errorf("expected the command line to keep its value, got %q", config.Statsd.Host)
+	}
+
+	if err := ioutil.WriteFile(iniPath, []byte("statsd_hostname = typo\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := load(); err == nil {
+		t.Error("expected an error for an unknown setting in the ini file")
+	}
+}
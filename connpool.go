@@ -0,0 +1,58 @@
+package mgostatsd
+
+import "gopkg.in/mgo.v2"
+
+type ConnPoolHost struct {
+	InUse      int64 `bson:"inUse" metric:"in_use"`
+	Available  int64 `bson:"available" metric:"available"`
+	Created    int64 `bson:"created" metric:"created,counter,rate"`
+	Refreshing int64 `bson:"refreshing" metric:"refreshing"`
+}
+
+type ConnPoolStats struct {
+	NumClientConnections  int64                   `bson:"numClientConnections" metric:"client_connections"`
+	NumAScopedConnections int64                   `bson:"numAScopedConnections" metric:"scoped_connections"`
+	TotalInUse            int64                   `bson:"totalInUse" metric:"in_use"`
+	TotalAvailable        int64                   `bson:"totalAvailable" metric:"available"`
+	TotalCreated          int64                   `bson:"totalCreated" metric:"created,counter,rate"`
+	TotalRefreshing       int64                   `bson:"totalRefreshing" metric:"refreshing"`
+	Hosts                 map[string]ConnPoolHost `bson:"hosts" metric:"hosts"`
+}
+
+// GetConnPoolStats returns a struct of the MongoDB 'connPoolStats' command response
+func GetConnPoolStats(session *mgo.Session) (*ConnPoolStats, error) {
+	var s *ConnPoolStats
+	err := session.Run("connPoolStats", &s)
+	return s, err
+}
+
+func pushConnPoolStats(sink Sink, stats *ConnPoolStats) error {
+	named := *stats
+	named.Hosts = make(map[string]ConnPoolHost, len(stats.Hosts))
+	for host, h := range stats.Hosts {
+		named.Hosts[metricHost(host)] = h
+	}
+
+	for _, metric := range Flatten("connpool", &named) {
+		err := sink.Gauge(metric.Name, metric.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConnPoolCollector emits the outgoing connection pools to other members, shards and config
+// servers, in total and per remote host
+type ConnPoolCollector struct{}
+
+func (c *ConnPoolCollector) Collect(session *mgo.Session, sink Sink) error {
+	stats, err := GetConnPoolStats(session)
+	if isCommandUnsupported(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return pushConnPoolStats(sink, stats)
+}
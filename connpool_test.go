@@ -0,0 +1,47 @@
+package mgostatsd
+
+import "testing"
+
+func TestPushConnPoolStats(t *testing.T) {
+	stats := &ConnPoolStats{
+		NumClientConnections: 4,
+		TotalInUse:           3,
+		TotalAvailable:       12,
+		TotalCreated:         40,
+		Hosts: map[string]ConnPoolHost{
+			"db2.example.com:27017": {InUse: 1, Available: 5, Created: 15},
+			"db3.example.com:27017": {InUse: 2, Available: 7, Created: 25, Refreshing: 1},
+		},
+	}
+
+	sink := newRecordingSink()
+	if err := pushConnPoolStats(sink, stats); err != nil {
+		t.Fatalf("pushConnPoolStats failed: %v", err)
+	}
+
+	expected := map[string]int64{
+		"connpool.client_connections":                     4,
+		"connpool.in_use":                                 3,
+		"connpool.available":                              12,
+		"connpool.created":                                40,
+		"connpool.hosts.db2_example_com-27017.available":  5,
+		"connpool.hosts.db3_example_com-27017.created":    25,
+		"connpool.hosts.db3_example_com-27017.refreshing": 1,
+	}
+	for name, want := range expected {
+		if got, ok := sink.gauges[name]; !ok || got != want {
+			t.Errorf("%s: expected %d, got %d", name, want, got)
+		}
+	}
+	for _, name := range []string{"connpool.created", "connpool.hosts.db2_example_com-27017.created"} {
+		if !IsCounter(name) {
+			t.Errorf("expected %s to be a counter", name)
+		}
+	}
+	if IsCounter("connpool.in_use") {
+		t.Error("expected connpool.in_use not to be a counter")
+	}
+	if !IsRate("connpool.hosts.db2_example_com-27017.created") {
+		t.Error("expected created connections to be sent as a rate")
+	}
+}
@@ -0,0 +1,116 @@
+package mgostatsd
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Modes for emitting cumulative counters
+const (
+	// CountersGauge emits the raw cumulative value as a gauge, except for the counters marked as
+	// rates, see IsRate
+	CountersGauge = "gauge"
+	// CountersDelta emits the increase since the previous sample as a StatsD counter
+	CountersDelta = "delta"
+	// CountersRate emits the increase per second since the previous sample as a fractional gauge
+	CountersRate = "rate"
+)
+
+// counterMetrics holds globs of the metric names of monotonically increasing counters rather than
+// point in time values, as declared by the counter option of their metric tags
+var counterMetrics = taggedMetrics("counter")
+
+// rateMetrics holds globs of the counters whose raw value means little on a graph, such as bytes
+// received or asserts raised, as declared by the rate option of their metric tags
+var rateMetrics = taggedMetrics("rate")
+
+// taggedMetrics returns globs of the metrics marked by option, of serverStatus both as named by
+// Flatten and by their paths with -raw_status, and of connPoolStats
+func taggedMetrics(option string) []string {
+	status := reflect.TypeOf(ServerStatus{})
+	patterns := optionPatterns(nil, option, "", status, false, false)
+	patterns = optionPatterns(patterns, option, "", status, false, true)
+	return optionPatterns(patterns, option, "connpool", reflect.TypeOf(ConnPoolStats{}), false, false)
+}
+
+// IsCounter reports whether the metric name is declared as a cumulative counter
+func IsCounter(name string) bool {
+	return matchAny(counterMetrics, name)
+}
+
+// IsRate reports whether the counter name is sent as a rate per second with -counters=gauge
+func IsRate(name string) bool {
+	return IsCounter(name) && matchAny(rateMetrics, name)
+}
+
+// restartDetector tells from the uptime of the successive samples of a server whether it restarted
+// in between. Its counters then started over, so the previous sample must be discarded rather than
+// compared with the new one.
+type restartDetector struct {
+	uptime int64
+}
+
+// restarted records uptime and reports whether it went backwards since the previous sample
+func (d *restartDetector) restarted(uptime int64) bool {
+	restarted := uptime < d.uptime
+	d.uptime = uptime
+	return restarted
+}
+
+// CounterTracker keeps the previous sample of every counter metric of one polled address,
+// so counters can be emitted as per-interval deltas or per-second rates
+type CounterTracker struct {
+	mode     string
+	prev     map[string]int64
+	last     time.Time
+	restarts restartDetector
+}
+
+// NewCounterTracker creates a tracker for one of the CountersGauge, CountersDelta or CountersRate modes
+func NewCounterTracker(mode string) (*CounterTracker, error) {
+	switch mode {
+	case CountersGauge, CountersDelta, CountersRate:
+	default:
+		return nil, fmt.Errorf("Unknown counter mode %q, expected %s, %s or %s", mode, CountersGauge, CountersDelta, CountersRate)
+	}
+	return &CounterTracker{mode: mode, prev: make(map[string]int64)}, nil
+}
+
+// Wrap returns a Sink converting counter gauges written to it for a sample taken at now, from a
+// server that has been up for uptime seconds
+func (t *CounterTracker) Wrap(sink Sink, uptime int64, now time.Time) Sink {
+	if t.restarts.restarted(uptime) {
+		t.prev = make(map[string]int64)
+	}
+	elapsed := now.Sub(t.last)
+	t.last = now
+	return &counterSink{Sink: sink, tracker: t, elapsed: elapsed}
+}
+
+type counterSink struct {
+	Sink
+	tracker *CounterTracker
+	elapsed time.Duration
+}
+
+func (s *counterSink) Gauge(name string, value int64, tags ...Tag) error {
+	if !IsCounter(name) || s.tracker.mode == CountersGauge && !IsRate(name) {
+		return s.Sink.Gauge(name, value, tags...)
+	}
+
+	prev, ok := s.tracker.prev[name]
+	s.tracker.prev[name] = value
+	if !ok || value < prev {
+		return nil // nothing to compare against yet, or the counter was reset
+	}
+
+	delta := value - prev
+	if s.tracker.mode == CountersDelta {
+		return s.Sink.Counter(name, delta, tags...)
+	}
+	if s.elapsed <= 0 {
+		return nil
+	}
+	return s.Sink.GaugeFloat(name, float64(delta)/s.elapsed.Seconds(), tags...)
+}
@@ -0,0 +1,130 @@
+package mgostatsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIsCounter(t *testing.T) {
+	for _, name := range []string{"ops.inserts", "metrics.commands.find.total", "wiredtiger.cache.bytes_read_into_cache",
+		"wiredtiger.txn.transactions_committed", "wiredtiger.block_manager.bytes_read", "wiredtiger.log.log_sync_time_duration_usecs_"} {
+		if !IsCounter(name) {
+			t.Errorf("expected %s to be a counter", name)
+		}
+	}
+	for _, name := range []string{"connections.current", "mem.resident", "wiredtiger.cache.bytes_currently_in_the_cache",
+		"wiredtiger.txn.transaction_checkpoint_currently_running", "wiredtiger.session.open_session_count", "wiredtiger.cache_fill_percent"} {
+		if IsCounter(name) {
+			t.Errorf("expected %s not to be a counter", name)
+		}
+	}
+}
+
+func TestCounterTrackerDelta(t *testing.T) {
+	tracker, err := NewCounterTracker(CountersDelta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+
+	sink := newRecordingSink()
+	s := tracker.Wrap(sink, 100, start)
+	s.Gauge("ops.inserts", 1000)
+	s.Gauge("connections.current", 5)
+	if _, ok := sink.counters["ops.inserts"]; ok {
+		t.Error("expected no counter on the first sample")
+	}
+	if sink.gauges["connections.current"] != 5 {
+		t.Error("expected gauges to pass through")
+	}
+
+	s = tracker.Wrap(sink, 105, start.Add(5*time.Second))
+	s.Gauge("ops.inserts", 1250)
+	if sink.counters["ops.inserts"] != 250 {
+		t.Errorf("expected a delta of 250, got %d", sink.counters["ops.inserts"])
+	}
+
+	// mongod restarted, the counter starts over
+	s = tracker.Wrap(sink, 2, start.Add(10*time.Second))
+	s.Gauge("ops.inserts", 10)
+	if sink.counters["ops.inserts"] != 250 {
+		t.Errorf("expected no delta across a restart, got %d", sink.counters["ops.inserts"]-250)
+	}
+}
+
+func TestCounterTrackerRate(t *testing.T) {
+	tracker, _ := NewCounterTracker(CountersRate)
+	start := time.Now()
+
+	sink := newRecordingSink()
+	tracker.Wrap(sink, 100, start).Gauge("ops.queries", 100)
+	tracker.Wrap(sink, 110, start.Add(10*time.Second)).Gauge("ops.queries", 600)
+	if sink.floats["ops.queries"] != 50 {
+		t.Errorf("expected a rate of 50/s, got %v", sink.floats["ops.queries"])
+	}
+
+	// one increase every 20s is still visible
+	tracker.Wrap(sink, 130, start.Add(30*time.Second)).Gauge("ops.queries", 601)
+	if sink.floats["ops.queries"] != 0.05 {
+		t.Errorf("expected a rate of 0.05/s, got %v", sink.floats["ops.queries"])
+	}
+}
+
+func TestCounterTrackerGaugeRates(t *testing.T) {
+	tracker, _ := NewCounterTracker(CountersGauge)
+	start := time.Now()
+
+	sink := newRecordingSink()
+	tracker.Wrap(sink, 100, start).Gauge("network.bytes_in", 1000)
+	if _, ok := sink.floats["network.bytes_in"]; ok {
+		t.Error("expected no rate on the first sample")
+	}
+	s := tracker.Wrap(sink, 130, start.Add(30*time.Second))
+	s.Gauge("network.bytes_in", 4000)
+	s.Gauge("asserts.user", 2)
+	s.Gauge("ops.inserts", 50)
+	if sink.floats["network.bytes_in"] != 100 {
+		t.Errorf("expected a rate of 100/s, got %v", sink.floats["network.bytes_in"])
+	}
+	if sink.gauges["ops.inserts"] != 50 {
+		t.Errorf("expected counters without the rate option to pass through, got %d", sink.gauges["ops.inserts"])
+	}
+	for _, name := range []string{"asserts.regular", "network.compression.snappy.compressor.bytes_in", "asserts.rollovers", "network.bytesOut"} {
+		if !IsRate(name) {
+			t.Errorf("expected %s to be sent as a rate", name)
+		}
+	}
+	if IsRate("ops.inserts") {
+		t.Error("expected ops.inserts not to be sent as a rate")
+	}
+}
+
+func TestNewCounterTrackerInvalid(t *testing.T) {
+	if _, err := NewCounterTracker("bogus"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestCounterPatterns(t *testing.T) {
+	type section struct {
+		Total   int64            `metric:"total,counter"`
+		Current int64            `metric:"current"`
+		Mixed   map[string]int64 `metric:"mixed,counter=bytes_*|*_calls"`
+		All     map[string]int64 `metric:"all,counter"`
+	}
+	type status struct {
+		Section  section  `metric:"section"`
+		Counters *section `metric:"counters_,counter"`
+		Skipped  int64
+	}
+
+	patterns := optionPatterns(nil, "counter", "", reflect.TypeOf(status{}), false, false)
+	expected := []string{
+		"section.total", "section.mixed.bytes_*", "section.mixed.*_calls", "section.all.*",
+		"counters_total", "counters_current", "counters_mixed.bytes_*", "counters_mixed.*_calls", "counters_all.*",
+	}
+	if !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("expected %q, got %q", expected, patterns)
+	}
+}
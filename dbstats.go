@@ -0,0 +1,309 @@
+package mgostatsd
+
+import (
+	"fmt"
+	"sort"
+	str "strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type DbStats struct {
+	Collections int64 `bson:"collections"`
+	Objects     int64 `bson:"objects"`
+	DataSize    int64 `bson:"dataSize"`
+	StorageSize int64 `bson:"storageSize"`
+	Indexes     int64 `bson:"indexes"`
+	IndexSize   int64 `bson:"indexSize"`
+}
+
+type CollStats struct {
+	Count          int64 `bson:"count"`
+	Size           int64 `bson:"size"`
+	StorageSize    int64 `bson:"storageSize"`
+	TotalIndexSize int64 `bson:"totalIndexSize"`
+	Indexes        int64 `bson:"nindexes"`
+}
+
+// GetDbStats returns a struct of the MongoDB 'dbStats' command response for database name
+func GetDbStats(session *mgo.Session, name string) (*DbStats, error) {
+	var s *DbStats
+	err := session.DB(name).Run(bson.D{{Name: "dbStats", Value: 1}}, &s)
+	return s, err
+}
+
+// GetCollStats returns a struct of the MongoDB 'collStats' command response for collection name of database db
+func GetCollStats(session *mgo.Session, db, name string) (*CollStats, error) {
+	var s *CollStats
+	err := session.DB(db).Run(bson.D{{Name: "collStats", Value: name}}, &s)
+	return s, err
+}
+
+// metricComponent turns a database or collection name into a single metric path component
+func metricComponent(name string) string {
+	return badMetricChars.ReplaceAllLiteralString(name, "_")
+}
+
+func pushDbStats(sink Sink, name string, stats *DbStats) error {
+	var err error
+	prefix := fmt.Sprintf("db.%s", metricComponent(name))
+
+	err = sink.Gauge(prefix+".data_size", stats.DataSize)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge(prefix+".storage_size", stats.StorageSize)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge(prefix+".index_size", stats.IndexSize)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge(prefix+".objects", stats.Objects)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge(prefix+".collections", stats.Collections)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge(prefix+".indexes", stats.Indexes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func pushCollStats(sink Sink, db, name string, stats *CollStats) error {
+	var err error
+	prefix := fmt.Sprintf("db.%s.coll.%s", metricComponent(db), metricComponent(name))
+
+	err = sink.Gauge(prefix+".size", stats.Size)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge(prefix+".storage_size", stats.StorageSize)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge(prefix+".index_size", stats.TotalIndexSize)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge(prefix+".count", stats.Count)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge(prefix+".indexes", stats.Indexes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// collectionInfo is an entry of the 'listCollections' command response
+type collectionInfo struct {
+	Name string `bson:"name"`
+	Type string `bson:"type"`
+}
+
+// storedCollections returns the sorted names of the collections in infos, without the views,
+// which have no storage of their own and fail collStats
+func storedCollections(infos []collectionInfo) []string {
+	var names []string
+	for _, info := range infos {
+		if info.Type == "view" {
+			continue
+		}
+		names = append(names, info.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetCollectionNames returns the names of the collections of database db, without its views
+func GetCollectionNames(session *mgo.Session, db string) ([]string, error) {
+	var result struct {
+		Cursor struct {
+			FirstBatch []bson.Raw `bson:"firstBatch"`
+			NS         string     `bson:"ns"`
+			ID         int64      `bson:"id"`
+		} `bson:"cursor"`
+	}
+	database := session.DB(db)
+	err := database.Run(bson.D{{Name: "listCollections", Value: 1}, {Name: "cursor", Value: bson.M{}}}, &result)
+	if isCommandUnsupported(err) {
+		return database.CollectionNames() // before 3.0, which had no views either
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	coll := database.C("$cmd.listCollections")
+	if ns := str.SplitN(result.Cursor.NS, ".", 2); len(ns) == 2 {
+		coll = session.DB(ns[0]).C(ns[1])
+	}
+	var infos []collectionInfo
+	err = coll.NewIter(nil, result.Cursor.FirstBatch, result.Cursor.ID, nil).All(&infos)
+	if err != nil {
+		return nil, err
+	}
+	return storedCollections(infos), nil
+}
+
+// dbStatsSource lists the databases and collections of a server and returns their stats
+type dbStatsSource interface {
+	DatabaseNames() ([]string, error)
+	CollectionNames(db string) ([]string, error)
+	DbStats(db string) (*DbStats, error)
+	CollStats(db, name string) (*CollStats, error)
+}
+
+// sessionStatsSource is the dbStatsSource of the server a session is connected to
+type sessionStatsSource struct {
+	session *mgo.Session
+}
+
+func (s sessionStatsSource) DatabaseNames() ([]string, error) {
+	return s.session.DatabaseNames()
+}
+
+func (s sessionStatsSource) CollectionNames(db string) ([]string, error) {
+	return GetCollectionNames(s.session, db)
+}
+
+func (s sessionStatsSource) DbStats(db string) (*DbStats, error) {
+	return GetDbStats(s.session, db)
+}
+
+func (s sessionStatsSource) CollStats(db, name string) (*CollStats, error) {
+	return GetCollStats(s.session, db, name)
+}
+
+// skippedNamespaces records the databases and collections whose stats failed, so the others
+// are still emitted
+type skippedNamespaces struct {
+	names []string
+	first error
+}
+
+func (s *skippedNamespaces) add(ns string, err error) {
+	s.names = append(s.names, ns)
+	if s.first == nil {
+		s.first = err
+	}
+}
+
+func (s *skippedNamespaces) err() error {
+	if len(s.names) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Skipped the stats of %s: %v", str.Join(s.names, ", "), s.first)
+}
+
+// DbStatsCollector emits dbStats for every database passing Databases and, when Collections
+// is enabled, collStats for every collection whose "db.collection" name passes CollectionFilter.
+// A database or collection whose stats fail, e.g. because it was dropped meanwhile, is skipped
+// and reported once all the others are emitted.
+type DbStatsCollector struct {
+	Databases        Filter
+	Collections      bool
+	CollectionFilter Filter
+}
+
+func newDbStatsCollector(config Config) (Collector, error) {
+	err := config.Storage.Databases.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = config.Storage.CollectionFilter.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &DbStatsCollector{
+		Databases:        config.Storage.Databases,
+		Collections:      config.Storage.Collections,
+		CollectionFilter: config.Storage.CollectionFilter,
+	}, nil
+}
+
+func (c *DbStatsCollector) Collect(session *mgo.Session, sink Sink) error {
+	return c.collect(sessionStatsSource{session}, sink)
+}
+
+func (c *DbStatsCollector) collect(source dbStatsSource, sink Sink) error {
+	names, err := source.DatabaseNames()
+	if err != nil {
+		return err
+	}
+
+	var skipped skippedNamespaces
+	for _, name := range names {
+		if !c.Databases.Match(name) {
+			continue
+		}
+		stats, err := source.DbStats(name)
+		if IsNetworkError(err) {
+			return err
+		}
+		if err != nil {
+			skipped.add(name, err)
+			continue
+		}
+		err = pushDbStats(sink, name, stats)
+		if err != nil {
+			return err
+		}
+
+		if c.Collections {
+			err = c.collectCollections(source, sink, name, &skipped)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return skipped.err()
+}
+
+func (c *DbStatsCollector) collectCollections(source dbStatsSource, sink Sink, db string, skipped *skippedNamespaces) error {
+	names, err := source.CollectionNames(db)
+	if IsNetworkError(err) {
+		return err
+	}
+	if err != nil {
+		skipped.add(db+".*", err)
+		return nil
+	}
+
+	for _, name := range names {
+		if str.HasPrefix(name, "system.") || !c.CollectionFilter.Match(db+"."+name) {
+			continue
+		}
+		stats, err := source.CollStats(db, name)
+		if IsNetworkError(err) {
+			return err
+		}
+		if err != nil {
+			skipped.add(db+"."+name, err)
+			continue
+		}
+		err = pushCollStats(sink, db, name, stats)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
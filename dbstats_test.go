@@ -0,0 +1,176 @@
+package mgostatsd
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	str "strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPushDbStats(t *testing.T) {
+	data, err := bson.Marshal(bson.M{
+		"db": "app", "collections": 4, "views": 1, "objects": int64(1000), "avgObjSize": 512.5,
+		"dataSize": 512000.0, "storageSize": int64(1 << 20), "indexes": 6, "indexSize": int64(1 << 16), "ok": 1.0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats DbStats
+	if err := bson.Unmarshal(data, &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newRecordingSink()
+	if err := pushDbStats(sink, "app.v2", &stats); err != nil {
+		t.Fatalf("pushDbStats failed: %v", err)
+	}
+
+	expected := map[string]int64{
+		"db.app_v2.data_size":    512000,
+		"db.app_v2.storage_size": 1 << 20,
+		"db.app_v2.index_size":   1 << 16,
+		"db.app_v2.objects":      1000,
+		"db.app_v2.collections":  4,
+		"db.app_v2.indexes":      6,
+	}
+	if len(sink.gauges) != len(expected) {
+		t.Errorf("expected %d metrics, got %v", len(expected), sink.gauges)
+	}
+	for name, want := range expected {
+		if got := sink.gauges[name]; got != want {
+			t.Errorf("%s: expected %d, got %d", name, want, got)
+		}
+	}
+}
+
+func TestPushCollStats(t *testing.T) {
+	data, err := bson.Marshal(bson.M{
+		"ns": "app.users", "count": 250, "size": int64(64000), "storageSize": int64(1 << 16),
+		"nindexes": 2, "totalIndexSize": int64(1 << 14), "indexSizes": bson.M{"_id_": int64(1 << 13)}, "ok": 1.0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats CollStats
+	if err := bson.Unmarshal(data, &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newRecordingSink()
+	if err := pushCollStats(sink, "app", "users", &stats); err != nil {
+		t.Fatalf("pushCollStats failed: %v", err)
+	}
+
+	expected := map[string]int64{
+		"db.app.coll.users.size":         64000,
+		"db.app.coll.users.storage_size": 1 << 16,
+		"db.app.coll.users.index_size":   1 << 14,
+		"db.app.coll.users.count":        250,
+		"db.app.coll.users.indexes":      2,
+	}
+	if len(sink.gauges) != len(expected) {
+		t.Errorf("expected %d metrics, got %v", len(expected), sink.gauges)
+	}
+	for name, want := range expected {
+		if got := sink.gauges[name]; got != want {
+			t.Errorf("%s: expected %d, got %d", name, want, got)
+		}
+	}
+}
+
+func TestStoredCollections(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"firstBatch": []bson.M{
+		{"name": "users", "type": "collection"},
+		{"name": "active_users", "type": "view", "options": bson.M{"viewOn": "users"}},
+		{"name": "events"}, // before 3.4 there is no type
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch struct {
+		FirstBatch []collectionInfo `bson:"firstBatch"`
+	}
+	if err := bson.Unmarshal(data, &batch); err != nil {
+		t.Fatal(err)
+	}
+
+	names := storedCollections(batch.FirstBatch)
+	if expected := []string{"events", "users"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+// fakeStatsSource serves dbStats and collStats from maps, failing for the namespaces in errs
+type fakeStatsSource struct {
+	collections map[string][]string
+	errs        map[string]error
+}
+
+func (f *fakeStatsSource) DatabaseNames() ([]string, error) {
+	var names []string
+	for name := range f.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f *fakeStatsSource) CollectionNames(db string) ([]string, error) {
+	return f.collections[db], f.errs[db+".*"]
+}
+
+func (f *fakeStatsSource) DbStats(db string) (*DbStats, error) {
+	if err := f.errs[db]; err != nil {
+		return nil, err
+	}
+	return &DbStats{Collections: int64(len(f.collections[db]))}, nil
+}
+
+func (f *fakeStatsSource) CollStats(db, name string) (*CollStats, error) {
+	if err := f.errs[db+"."+name]; err != nil {
+		return nil, err
+	}
+	return &CollStats{Count: 1}, nil
+}
+
+func TestDbStatsCollectorSkipsFailures(t *testing.T) {
+	source := &fakeStatsSource{
+		collections: map[string][]string{
+			"app":     {"users", "dropped", "system.profile", "orders"},
+			"broken":  {"items"},
+			"reports": {"daily"},
+		},
+		errs: map[string]error{
+			"app.dropped": errors.New("ns not found"),
+			"broken":      errors.New("not authorized on broken"),
+			"reports.*":   errors.New("not authorized on reports"),
+		},
+	}
+	collector := &DbStatsCollector{Collections: true}
+
+	sink := newRecordingSink()
+	err := collector.collect(source, sink)
+	if err == nil || !str.Contains(err.Error(), "app.dropped, broken, reports.*") || !str.Contains(err.Error(), "ns not found") {
+		t.Errorf("expected the skipped namespaces to be reported, got %v", err)
+	}
+	for _, name := range []string{"db.app.collections", "db.reports.collections", "db.app.coll.users.count", "db.app.coll.orders.count"} {
+		if _, ok := sink.gauges[name]; !ok {
+			t.Errorf("expected %s despite the failures, got %v", name, sink.gauges)
+		}
+	}
+	for _, name := range []string{"db.broken.collections", "db.broken.coll.items.count", "db.app.coll.dropped.count", "db.app.coll.system_profile.count"} {
+		if _, ok := sink.gauges[name]; ok {
+			t.Errorf("expected no %s", name)
+		}
+	}
+
+	// a lost connection aborts the collector, the poller reconnects
+	source.errs["app.dropped"] = io.EOF
+	if err := collector.collect(source, newRecordingSink()); err != io.EOF {
+		t.Errorf("expected the network error, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package mgostatsd
+
+// WiredTiger statistics the derived metrics are computed from
+const (
+	wtCacheMaxBytes   = "maximum bytes configured"
+	wtCacheBytes      = "bytes currently in the cache"
+	wtCacheDirtyBytes = "tracked dirty bytes in the cache"
+	wtTicketsOut      = "out"
+	wtTicketsTotal    = "totalTickets"
+)
+
+// percent returns part as a percentage of whole, and false when whole is not positive
+func percent(part, whole int64) (float64, bool) {
+	if whole <= 0 {
+		return 0, false
+	}
+	return float64(part) * 100 / float64(whole), true
+}
+
+// ratioMetric is a derived percentage with its metric name
+type ratioMetric struct {
+	name  string
+	value float64
+}
+
+// derivedMetrics returns the cache fill and dirty ratios and the read and write ticket utilization
+func (w *WiredTigerInfo) derivedMetrics() []ratioMetric {
+	var metrics []ratioMetric
+	for _, ratio := range []struct {
+		name        string
+		part, whole int64
+	}{
+		{"wiredtiger.cache_fill_percent", w.Cache[wtCacheBytes], w.Cache[wtCacheMaxBytes]},
+		{"wiredtiger.cache_dirty_percent", w.Cache[wtCacheDirtyBytes], w.Cache[wtCacheMaxBytes]},
+		{"wiredtiger.read_ticket_utilization_percent", w.ConcurrentTransactions.Read[wtTicketsOut], w.ConcurrentTransactions.Read[wtTicketsTotal]},
+		{"wiredtiger.write_ticket_utilization_percent", w.ConcurrentTransactions.Write[wtTicketsOut], w.ConcurrentTransactions.Write[wtTicketsTotal]},
+	} {
+		if value, ok := percent(ratio.part, ratio.whole); ok {
+			metrics = append(metrics, ratioMetric{ratio.name, value})
+		}
+	}
+	return metrics
+}
+
+// fragmentationPercent returns the memory held by tcmalloc but not allocated to mongod, as a
+// percentage of the heap not returned to the OS
+func (t *Tcmalloc) fragmentationPercent() (float64, bool) {
+	committed := t.Generic.HeapSize - t.Tcmalloc.PageheapUnmappedBytes
+	return percent(committed-t.Generic.CurrentAllocatedBytes, committed)
+}
+
+// WriteDerivedStats writes the ratios computed from several fields of status to sink as
+// fractional gauges, both with the known fields and with -raw_status
+func WriteDerivedStats(sink Sink, status *ServerStatus) error {
+	var metrics []ratioMetric
+	if status.Tcmalloc != nil {
+		if value, ok := status.Tcmalloc.fragmentationPercent(); ok {
+			metrics = append(metrics, ratioMetric{"mem.fragmentation_percent", value})
+		}
+	}
+	if status.WiredTiger != nil {
+		metrics = append(metrics, status.WiredTiger.derivedMetrics()...)
+	}
+	for _, metric := range metrics {
+		err := sink.GaugeFloat(metric.name, metric.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package mgostatsd
+
+import "testing"
+
+func TestWriteDerivedStats(t *testing.T) {
+	status := &ServerStatus{
+		WiredTiger: &WiredTigerInfo{
+			Cache: map[string]int64{
+				"maximum bytes configured":         1000,
+				"bytes currently in the cache":     800,
+				"tracked dirty bytes in the cache": 50,
+			},
+			ConcurrentTransactions: ConcurrentTransactionsInfo{
+				Write: map[string]int64{"out": 32, "available": 96, "totalTickets": 128},
+				Read:  map[string]int64{"out": 0, "available": 128, "totalTickets": 128},
+			},
+		},
+	}
+
+	sink := newRecordingSink()
+	if err := WriteDerivedStats(sink, status); err != nil {
+		t.Fatalf("WriteDerivedStats failed: %v", err)
+	}
+
+	expected := map[string]float64{
+		"wiredtiger.cache_fill_percent":               80,
+		"wiredtiger.cache_dirty_percent":              5,
+		"wiredtiger.read_ticket_utilization_percent":  0,
+		"wiredtiger.write_ticket_utilization_percent": 25,
+	}
+	for name, want := range expected {
+		if got, ok := sink.floats[name]; !ok || got != want {
+			t.Errorf("%s: expected %v, got %v (present: %v)", name, want, got, ok)
+		}
+	}
+
+	if _, ok := sink.floats["mem.fragmentation_percent"]; ok {
+		t.Error("expected no fragmentation without tcmalloc")
+	}
+
+	// MMAPv1 has no WiredTiger section to derive anything from
+	sink = newRecordingSink()
+	if err := WriteDerivedStats(sink, &ServerStatus{}); err != nil || len(sink.floats) > 0 {
+		t.Errorf("expected nothing without WiredTiger, got %v (%v)", sink.floats, err)
+	}
+}
+
+func TestWriteDerivedStatsTcmalloc(t *testing.T) {
+	status := &ServerStatus{
+		Tcmalloc: &Tcmalloc{
+			Generic:  TcmallocGeneric{CurrentAllocatedBytes: 600, HeapSize: 1200},
+			Tcmalloc: TcmallocStats{PageheapUnmappedBytes: 200, PageheapFreeBytes: 300},
+		},
+	}
+
+	sink := newRecordingSink()
+	if err := WriteDerivedStats(sink, status); err != nil {
+		t.Fatalf("WriteDerivedStats failed: %v", err)
+	}
+	if got := sink.floats["mem.fragmentation_percent"]; got != 40 {
+		t.Errorf("expected a fragmentation of 40%%, got %v", got)
+	}
+
+	// a cache a fraction of a percent dirty doesn't read as clean
+	sink = newRecordingSink()
+	WriteDerivedStats(sink, &ServerStatus{WiredTiger: &WiredTigerInfo{Cache: map[string]int64{
+		"maximum bytes configured":         1 << 30,
+		"tracked dirty bytes in the cache": 1 << 22,
+	}}})
+	if got := sink.floats["wiredtiger.cache_dirty_percent"]; got != 0.390625 {
+		t.Errorf("expected 0.390625%% dirty, got %v", got)
+	}
+
+	metrics := make(map[string]int64)
+	for _, metric := range Flatten("", status) {
+		metrics[metric.Name] = metric.Value
+	}
+	if metrics["tcmalloc.generic.heap_size"] != 1200 || metrics["tcmalloc.tcmalloc.pageheap_free_bytes"] != 300 {
+		t.Errorf("unexpected flattened metrics %v", metrics)
+	}
+}
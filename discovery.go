@@ -0,0 +1,260 @@
+package mgostatsd
+
+import (
+	"log"
+	"sort"
+	str "strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+type IsMasterResult struct {
+	IsMaster  bool     `bson:"ismaster"`
+	Secondary bool     `bson:"secondary"`
+	SetName   string   `bson:"setName"`
+	Hosts     []string `bson:"hosts"`
+	Passives  []string `bson:"passives"`
+	Arbiters  []string `bson:"arbiters"`
+	Me        string   `bson:"me"`
+	Msg       string   `bson:"msg"`
+	ConfigSvr int64    `bson:"configsvr"`
+}
+
+// GetIsMaster returns a struct of the MongoDB 'isMaster' command response
+func GetIsMaster(session *mgo.Session) (*IsMasterResult, error) {
+	var r *IsMasterResult
+	err := session.Run("isMaster", &r)
+	return r, err
+}
+
+// replSetMembers merges the members listed by isMaster with the ones from replSetGetStatus, which
+// also lists hidden members. status may be nil when replSetGetStatus isn't permitted.
+func replSetMembers(isMaster *IsMasterResult, status *ReplSetStatus) []string {
+	seen := make(map[string]bool)
+	var members []string
+	add := func(hosts ...string) {
+		for _, host := range hosts {
+			if host != "" && !seen[host] {
+				seen[host] = true
+				members = append(members, host)
+			}
+		}
+	}
+	add(isMaster.Hosts...)
+	add(isMaster.Passives...)
+	add(isMaster.Arbiters...)
+	if status != nil {
+		for _, member := range status.Members {
+			add(member.Name)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+type Shard struct {
+	ID    string `bson:"_id"`
+	Host  string `bson:"host"`
+	State int64  `bson:"state"`
+}
+
+// GetShards returns the shards of the cluster from the config.shards collection of a mongos
+func GetShards(session *mgo.Session) ([]Shard, error) {
+	var shards []Shard
+	err := session.DB("config").C("shards").Find(nil).Sort("_id").All(&shards)
+	return shards, err
+}
+
+type shardingStatus struct {
+	Sharding struct {
+		ConfigsvrConnectionString string `bson:"configsvrConnectionString"`
+	} `bson:"sharding"`
+}
+
+// GetConfigServers returns the config server connection string, e.g. "configRS/cfg1:27019,cfg2:27019",
+// from the 'serverStatus' command response of a mongos
+func GetConfigServers(session *mgo.Session) (string, error) {
+	var s shardingStatus
+	err := session.Run("serverStatus", &s)
+	return s.Sharding.ConfigsvrConnectionString, err
+}
+
+// parseShardHost splits a shard connection string "rs/host1,host2" into the replica set name
+// and the hosts, the name is empty for a standalone or mirrored config servers
+func parseShardHost(host string) (string, []string) {
+	setName := ""
+	if i := str.Index(host, "/"); i >= 0 {
+		setName, host = host[:i], host[i+1:]
+	}
+	var hosts []string
+	for _, h := range str.Split(host, ",") {
+		if h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return setName, hosts
+}
+
+// ConfigShard is the shard name the config servers are polled under
+const ConfigShard = "config"
+
+// Discoverer finds every member of the replica sets the seed addresses belong to. A seed that is a
+// mongos expands into itself, every member of every shard and the config servers.
+type Discoverer struct {
+	mongo Mongo
+	seeds []string
+	known map[string][]Target
+}
+
+// NewDiscoverer creates a Discoverer using the mongo addresses as seeds
+func NewDiscoverer(mongoConfig Mongo) *Discoverer {
+	return &Discoverer{
+		mongo: mongoConfig,
+		seeds: mongoConfig.Addresses,
+		known: make(map[string][]Target),
+	}
+}
+
+// Targets returns the discovered targets ordered by address. A seed that isn't part of a replica set
+// or cluster is returned as is, one that can't be reached contributes the targets found last time.
+func (d *Discoverer) Targets() []Target {
+	seen := make(map[string]bool)
+	var targets []Target
+	for _, seed := range d.seeds {
+		found, err := d.discover(seed)
+		if err != nil {
+			log.Printf("Error discovering members from %s: %v\n", seed, err)
+			found = d.known[seed]
+			if found == nil {
+				found = []Target{{Address: seed}}
+			}
+		} else {
+			d.known[seed] = found
+		}
+		for _, target := range found {
+			if !seen[target.Address] {
+				seen[target.Address] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Address < targets[j].Address })
+	return targets
+}
+
+func (d *Discoverer) discover(seed string) ([]Target, error) {
+	session, err := GetSession(d.mongo, seed)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	isMaster, err := GetIsMaster(session)
+	if err != nil {
+		return nil, err
+	}
+	if isMaster.Msg == "isdbgrid" {
+		return d.discoverCluster(session, seed)
+	}
+	members, err := membersOf(session, isMaster, seed)
+	if err != nil {
+		return nil, err
+	}
+	return AddressTargets(members), nil
+}
+
+// discoverCluster returns the mongos, the members of every shard and the config servers
+func (d *Discoverer) discoverCluster(session *mgo.Session, mongos string) ([]Target, error) {
+	shards, err := GetShards(session)
+	if err != nil {
+		return nil, err
+	}
+	configServers, err := GetConfigServers(session)
+	if err != nil {
+		return nil, err
+	}
+	if configServers != "" {
+		shards = append(shards, Shard{ID: ConfigShard, Host: configServers})
+	}
+
+	targets := []Target{{Address: mongos}}
+	for _, shard := range shards {
+		setName, hosts := parseShardHost(shard.Host)
+		members, err := d.discoverReplSet(setName, hosts)
+		if err != nil {
+			log.Printf("Error discovering members of shard %s: %v\n", shard.ID, err)
+			members = hosts
+		}
+		for _, member := range members {
+			targets = append(targets, Target{Address: member, Shard: shard.ID})
+		}
+	}
+	return targets, nil
+}
+
+// discoverReplSet returns the members of replica set setName from the first of seeds that answers
+func (d *Discoverer) discoverReplSet(setName string, seeds []string) ([]string, error) {
+	mongoConfig := d.mongo
+	mongoConfig.ReplicaSet = setName
+	if setName == "" {
+		return seeds, nil
+	}
+
+	var lastErr error
+	for _, seed := range seeds {
+		session, err := GetSession(mongoConfig, seed)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		isMaster, err := GetIsMaster(session)
+		if err == nil {
+			var members []string
+			members, err = membersOf(session, isMaster, seed)
+			if err == nil {
+				session.Close()
+				return members, nil
+			}
+		}
+		session.Close()
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// membersOf returns the replica set members known to the server of session, or server itself
+// when it isn't part of a replica set
+func membersOf(session *mgo.Session, isMaster *IsMasterResult, server string) ([]string, error) {
+	if isMaster.SetName == "" {
+		return []string{server}, nil
+	}
+	status, err := GetReplSetStatus(session)
+	if err != nil {
+		if IsNetworkError(err) {
+			return nil, err
+		}
+		status = nil // e.g. not authorized, isMaster still lists all visible members
+	}
+	return replSetMembers(isMaster, status), nil
+}
+
+// Run syncs pool with the discovered targets now and then on every interval until quit is closed
+func (d *Discoverer) Run(quit <-chan struct{}, interval time.Duration, pool *Pool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		added, removed, err := pool.Sync(d.Targets())
+		if err != nil {
+			log.Printf("Error starting pollers: %v\n", err)
+		}
+		if len(added) > 0 || len(removed) > 0 {
+			log.Printf("Discovered members changed, added %v, removed %v\n", added, removed)
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
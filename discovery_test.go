@@ -0,0 +1,128 @@
+package mgostatsd
+
+import (
+	"bytes"
+	"reflect"
+	str "strings"
+	"testing"
+	"time"
+
+	"github.com/cactus/go-statsd-client/statsd"
+)
+
+func TestReplSetMembers(t *testing.T) {
+	isMaster := &IsMasterResult{
+		SetName:  "rs0",
+		Hosts:    []string{"db2:27017", "db1:27017"},
+		Passives: []string{"db3:27017"},
+		Arbiters: []string{"arb:27017"},
+	}
+	expected := []string{"arb:27017", "db1:27017", "db2:27017", "db3:27017"}
+	if members := replSetMembers(isMaster, nil); !reflect.DeepEqual(members, expected) {
+		t.Errorf("expected %v, got %v", expected, members)
+	}
+
+	status := &ReplSetStatus{Members: []ReplSetMember{
+		{Name: "db1:27017"},
+		{Name: "hidden:27017"},
+	}}
+	expected = []string{"arb:27017", "db1:27017", "db2:27017", "db3:27017", "hidden:27017"}
+	if members := replSetMembers(isMaster, status); !reflect.DeepEqual(members, expected) {
+		t.Errorf("expected %v, got %v", expected, members)
+	}
+}
+
+func TestPoolSync(t *testing.T) {
+	config := Config{
+		Interval:  time.Second,
+		Counters:  CountersGauge,
+		Reconnect: Backoff{Min: time.Hour, Max: time.Hour, Factor: 2},
+		Mongo:     Mongo{Timeout: 10 * time.Millisecond},
+	}
+	pool := NewPool(config, &Outputs{})
+	defer pool.Stop()
+
+	added, removed, err := pool.Sync(AddressTargets([]string{"127.0.0.1:1", "127.0.0.1:2"}))
+	if err != nil || len(added) != 2 || len(removed) != 0 {
+		t.Fatalf("unexpected sync result added %v, removed %v, err %v", added, removed, err)
+	}
+
+	added, removed, err = pool.Sync([]Target{{Address: "127.0.0.1:2", Shard: "shard01"}, {Address: "127.0.0.1:3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := AddressTargets([]string{"127.0.0.1:1", "127.0.0.1:2"}); !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected removed %v, got %v", expected, removed)
+	}
+	if expected := []Target{{Address: "127.0.0.1:2", Shard: "shard01"}, {Address: "127.0.0.1:3"}}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected added %v, got %v", expected, added)
+	}
+
+	var servers []string
+	for _, poller := range pool.Pollers() {
+		servers = append(servers, poller.Target().String())
+	}
+	if !reflect.DeepEqual(servers, []string{"shard01/127.0.0.1:2", "127.0.0.1:3"}) {
+		t.Errorf("unexpected pollers %v", servers)
+	}
+}
+
+func TestParseShardHost(t *testing.T) {
+	setName, hosts := parseShardHost("shard01/db1:27018,db2:27018")
+	if setName != "shard01" || !reflect.DeepEqual(hosts, []string{"db1:27018", "db2:27018"}) {
+		t.Errorf("unexpected result %q %v", setName, hosts)
+	}
+	setName, hosts = parseShardHost("cfg1:27019,cfg2:27019,cfg3:27019")
+	if setName != "" || len(hosts) != 3 {
+		t.Errorf("unexpected result %q %v", setName, hosts)
+	}
+}
+
+func TestOutputsShardSink(t *testing.T) {
+	status := &ServerStatus{Host: "db1:27018", Process: "mongod"}
+	target := Target{Address: "db1:27018", Shard: "shard01"}
+
+	sender := &packetSender{}
+	client, _ := statsd.NewClientWithSender(sender, "")
+	statsdConfig := Statsd{Env: "prod", Cluster: "main"}
+	exporter := NewPrometheusExporter(statsdConfig)
+	outputs := &Outputs{Statsd: client, Prometheus: exporter, statsdConfig: statsdConfig}
+	sink := outputs.SinkFor(target, status, nil)
+	sink.Gauge("connections.current", 3)
+	sink.Flush()
+
+	if expected := "prod.main.shard01.db1-27018.connections.current:3|g"; len(sender.packets) != 1 || sender.packets[0] != expected {
+		t.Errorf("expected %q, got %v", expected, sender.packets)
+	}
+
+	var buf bytes.Buffer
+	exporter.Render(&buf)
+	if !str.Contains(buf.String(), `shard="shard01"`) {
+		t.Errorf("expected a shard label in\n%s", buf.String())
+	}
+}
+
+func TestOutputsCountersForStatsdOnly(t *testing.T) {
+	sender := &packetSender{}
+	client, _ := statsd.NewClientWithSender(sender, "")
+	statsdConfig := Statsd{Env: "prod", Cluster: "main"}
+	exporter := NewPrometheusExporter(statsdConfig)
+	outputs := &Outputs{Statsd: client, Prometheus: exporter, statsdConfig: statsdConfig}
+	tracker, _ := NewCounterTracker(CountersDelta)
+	target := Target{Address: "db1:27017"}
+
+	for i, value := range []int64{100, 130} {
+		sink := outputs.SinkFor(target, &ServerStatus{Host: "db1:27017", Uptime: int64(10 + i)}, tracker)
+		sink.Gauge("ops.inserts", value)
+		sink.Flush()
+	}
+
+	if expected := "prod.main.db1-27017.ops.inserts:30|c"; len(sender.packets) != 1 || sender.packets[0] != expected {
+		t.Errorf("expected %q, got %v", expected, sender.packets)
+	}
+	var buf bytes.Buffer
+	exporter.Render(&buf)
+	if want := `mongodb_ops_inserts_total{host="db1:27017",cluster="main",env="prod"} 130`; !str.Contains(buf.String(), want) {
+		t.Errorf("expected %q in\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,11 @@
+//go:build go1.13
+// +build go1.13
+
+package mgostatsd
+
+import "net"
+
+// isNotFound reports whether a DNS lookup failed because the record doesn't exist
+func isNotFound(err *net.DNSError) bool {
+	return err.IsNotFound
+}
@@ -0,0 +1,12 @@
+//go:build !go1.13
+// +build !go1.13
+
+package mgostatsd
+
+import "net"
+
+// isNotFound reports whether a DNS lookup failed because the record doesn't exist, net.DNSError
+// only has IsNotFound since Go 1.13
+func isNotFound(err *net.DNSError) bool {
+	return err.Err == "no such host"
+}
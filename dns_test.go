@@ -0,0 +1,32 @@
+//go:build go1.13
+// +build go1.13
+
+package mgostatsd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseSRVURIWithoutTXT(t *testing.T) {
+	defer func(srv func(string, string, string) (string, []*net.SRV, error), txt func(string) ([]string, error)) {
+		lookupSRV, lookupTXT = srv, txt
+	}(lookupSRV, lookupTXT)
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{{Target: "shard-00.example.com.", Port: 27017}}, nil
+	}
+	lookupTXT = func(name string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	if _, err := ParseURI("mongodb+srv://cluster0.example.com/"); err != nil {
+		t.Errorf("expected a missing TXT record to be ignored, got %v", err)
+	}
+
+	lookupTXT = func(name string) ([]string, error) {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	if _, err := ParseURI("mongodb+srv://cluster0.example.com/"); err == nil {
+		t.Error("expected a failing TXT lookup to be an error")
+	}
+}
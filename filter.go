@@ -0,0 +1,75 @@
+package mgostatsd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	str "strings"
+	"sync"
+)
+
+// regexPrefix marks a filter pattern as a regular expression instead of a glob
+const regexPrefix = "re:"
+
+// Filter selects names with glob patterns, or regular expressions when prefixed with "re:".
+// A name passes when it matches any Include pattern, or Include is empty, and matches none
+// of the Exclude patterns.
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+// Validate returns an error for the first malformed pattern
+func (f Filter) Validate() error {
+	for _, patterns := range [][]string{f.Include, f.Exclude} {
+		for _, pattern := range patterns {
+			var err error
+			if str.HasPrefix(pattern, regexPrefix) {
+				_, err = regexp.Compile(pattern[len(regexPrefix):])
+			} else {
+				_, err = path.Match(pattern, "")
+			}
+			if err != nil {
+				return fmt.Errorf("Invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Match reports whether name passes the filter
+func (f Filter) Match(name string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRegexps caches compiled "re:" patterns, filters are matched against every metric name
+var filterRegexps sync.Map
+
+func matchPattern(pattern, name string) bool {
+	if !str.HasPrefix(pattern, regexPrefix) {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+
+	re, ok := filterRegexps.Load(pattern)
+	if !ok {
+		compiled, err := regexp.Compile(pattern[len(regexPrefix):])
+		if err != nil {
+			return false
+		}
+		re, _ = filterRegexps.LoadOrStore(pattern, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(name)
+}
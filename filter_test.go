@@ -0,0 +1,44 @@
+package mgostatsd
+
+import "testing"
+
+func TestFilterMatch(t *testing.T) {
+	filter := Filter{Include: []string{"app*", "reporting"}, Exclude: []string{"app_tmp*"}}
+	cases := map[string]bool{
+		"app":         true,
+		"app_orders":  true,
+		"reporting":   true,
+		"app_tmp_123": false,
+		"local":       false,
+	}
+	for name, want := range cases {
+		if got := filter.Match(name); got != want {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+
+	if !(Filter{}).Match("anything") {
+		t.Error("expected an empty filter to match everything")
+	}
+	if err := (Filter{Include: []string{"[bad"}}).Validate(); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+	if err := (Filter{Exclude: []string{"re:(bad"}}).Validate(); err == nil {
+		t.Error("expected an error for a malformed regular expression")
+	}
+}
+
+func TestFilterRegexp(t *testing.T) {
+	filter := Filter{Include: []string{`re:^wiredTiger\.(cache|transaction)\.`, "network.*"}, Exclude: []string{"re:_hist$"}}
+	cases := map[string]bool{
+		"wiredTiger.cache.bytes_read_into_cache": true,
+		"wiredTiger.log.log_bytes_written":       false,
+		"network.bytesIn":                        true,
+		"network.latency_hist":                   false,
+	}
+	for name, want := range cases {
+		if got := filter.Match(name); got != want {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+}
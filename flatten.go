@@ -0,0 +1,174 @@
+package mgostatsd
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	str "strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Metric is a single flattened metric name and value
+type Metric struct {
+	Name  string
+	Value int64
+}
+
+var badMetricChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+// joinMetric appends name to prefix with a dot, unless prefix ends in "_" or "-",
+// in which case it is used as a literal prefix of name
+func joinMetric(prefix, name string) string {
+	if prefix == "" || str.HasSuffix(prefix, "_") || str.HasSuffix(prefix, "-") {
+		return prefix + name
+	}
+	return prefix + "." + name
+}
+
+// Flatten walks v and returns a metric for every numeric or bool value reachable through
+// fields tagged with `metric:"name"`. Nested structs, pointers and maps are followed, joining
+// names with dots, map keys are cleaned up to be usable as metric names. Fields without a
+// metric tag and BSON timestamps are skipped. The "omitempty" tag option skips map entries whose value is zero. The
+// "counter" option marks the field and everything below it as a cumulative counter, on a map
+// "counter=glob|glob" only marks the keys matching one of the globs, see IsCounter. The "rate"
+// option marks counters sent as rates even with -counters=gauge, see IsRate.
+func Flatten(prefix string, v interface{}) []Metric {
+	return flatten(nil, prefix, reflect.ValueOf(v), false)
+}
+
+// nonMetricTypes are the BSON types stored as integers that aren't quantities, such as the
+// timestamps of operationTime and opTime.ts in a raw serverStatus
+var nonMetricTypes = map[reflect.Type]bool{
+	reflect.TypeOf(bson.MongoTimestamp(0)): true,
+	reflect.TypeOf(bson.MinKey):            true,
+}
+
+func flatten(metrics []Metric, name string, v reflect.Value, omitempty bool) []Metric {
+	if v.IsValid() && nonMetricTypes[v.Type()] {
+		return metrics
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return metrics
+		}
+		return flatten(metrics, name, v.Elem(), omitempty)
+	case reflect.Bool:
+		var value int64
+		if v.Bool() {
+			value = 1
+		}
+		return append(metrics, Metric{Name: name, Value: value})
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return append(metrics, Metric{Name: name, Value: v.Int()})
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return append(metrics, Metric{Name: name, Value: int64(v.Uint())})
+	case reflect.Float32, reflect.Float64:
+		return append(metrics, Metric{Name: name, Value: int64(v.Float())})
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("metric")
+			if tag == "" || tag == "-" || field.PkgPath != "" {
+				continue
+			}
+			opts := str.Split(tag, ",")
+			metrics = flatten(metrics, joinMetric(name, opts[0]), v.Field(i), hasOption(opts[1:], "omitempty"))
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return metrics
+		}
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+
+		zero := reflect.Zero(v.Type().Elem()).Interface()
+		for _, key := range keys {
+			elem := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+			if omitempty && reflect.DeepEqual(elem.Interface(), zero) {
+				continue
+			}
+			metrics = flatten(metrics, joinMetric(name, badMetricChars.ReplaceAllLiteralString(key, "_")), elem, false)
+		}
+	}
+	return metrics
+}
+
+// optionPatterns appends globs matching the metric names, or with bsonPaths the paths of the
+// serverStatus fields as named by -raw_status, of every field of t marked by option in its tag
+func optionPatterns(patterns []string, option, name string, t reflect.Type, marked, bsonPaths bool) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("metric")
+			if tag == "" || tag == "-" || field.PkgPath != "" {
+				continue
+			}
+			opts := str.Split(tag, ",")
+			fieldName := joinMetric(name, opts[0])
+			if bsonPaths {
+				fieldName = joinPath(name, bsonName(field))
+			}
+			if keys, ok := optionValue(opts[1:], option); ok {
+				for _, key := range str.Split(keys, "|") {
+					patterns = append(patterns, fieldName+"."+key)
+				}
+				continue
+			}
+			patterns = optionPatterns(patterns, option, fieldName, field.Type, marked || hasOption(opts[1:], option), bsonPaths)
+		}
+	case reflect.Map:
+		if marked {
+			return append(patterns, name+".*")
+		}
+		return optionPatterns(patterns, option, name+".*", t.Elem(), false, bsonPaths)
+	default:
+		if marked {
+			patterns = append(patterns, name)
+		}
+	}
+	return patterns
+}
+
+// bsonName returns the name of field in BSON documents
+func bsonName(field reflect.StructField) string {
+	if name := str.Split(field.Tag.Get("bson"), ",")[0]; name != "" {
+		return name
+	}
+	return str.ToLower(field.Name)
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// optionValue returns the value of a "option=value" tag option
+func optionValue(opts []string, option string) (string, bool) {
+	for _, opt := range opts {
+		if str.HasPrefix(opt, option+"=") {
+			return opt[len(option)+1:], true
+		}
+	}
+	return "", false
+}
+
+func hasOption(opts []string, option string) bool {
+	for _, opt := range opts {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package mgostatsd
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type flattenInner struct {
+	Count int64   `metric:"count"`
+	Ratio float64 `metric:"ratio"`
+	Skip  int64
+}
+
+type flattenOuter struct {
+	Enabled bool                    `metric:"enabled"`
+	Inner   flattenInner            `metric:"inner"`
+	Queue   flattenInner            `metric:"queued_"`
+	Ptr     *flattenInner           `metric:"ptr"`
+	Entries map[string]flattenInner `metric:"entries,omitempty"`
+	Ignored int64                   `metric:"-"`
+	Name    string                  `metric:"name"`
+}
+
+func TestFlatten(t *testing.T) {
+	v := flattenOuter{
+		Enabled: true,
+		Inner:   flattenInner{Count: 2, Ratio: 1.5, Skip: 9},
+		Queue:   flattenInner{Count: 3},
+		Entries: map[string]flattenInner{
+			"b key": {Count: 1},
+			"a":     {},
+		},
+		Ignored: 7,
+		Name:    "x",
+	}
+
+	expected := []Metric{
+		{"test.enabled", 1},
+		{"test.inner.count", 2},
+		{"test.inner.ratio", 1},
+		{"test.queued_count", 3},
+		{"test.queued_ratio", 0},
+		{"test.entries.b_key.count", 1},
+		{"test.entries.b_key.ratio", 0},
+	}
+	if got := Flatten("test", &v); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+// TestServerStatusDecoding checks that the bson and metric tags of ServerStatus line up
+// with the serverStatus field names and the historical metric names
+func TestServerStatusDecoding(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{
+		"host":        "db1:27017",
+		"uptime":      100,
+		"connections": bson.M{"current": 3, "available": 97, "totalCreated": 50},
+		"extra_info":  bson.M{"page_faults": 4, "heap_usage_bytes": 4096},
+		"globalLock": bson.M{
+			"totalTime":     1000,
+			"currentQueue":  bson.M{"readers": 1, "writers": 2, "total": 3},
+			"activeClients": bson.M{"readers": 4, "writers": 5, "total": 9},
+		},
+		"opcounters": bson.M{"insert": 10, "getmore": 11},
+		"repl":       bson.M{"ismaster": true, "secondary": false},
+		"metrics": bson.M{
+			"commands": bson.M{"find": bson.M{"failed": 0, "total": 12}, "drop": bson.M{"failed": 0, "total": 0}},
+			"cursor":   bson.M{"timedOut": 1, "open": bson.M{"pinned": 2}},
+		},
+		"wiredTiger": bson.M{
+			"cache":                  bson.M{"bytes currently in the cache": 2048},
+			"concurrentTransactions": bson.M{"read": bson.M{"available": 128}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := &ServerStatus{}
+	if err := bson.Unmarshal(raw, status); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newRecordingSink()
+	if err := WriteStats(sink, status); err != nil {
+		t.Fatalf("WriteStats failed: %v", err)
+	}
+	expected := map[string]int64{
+		"uptime":                                        100,
+		"connections.created":                           50,
+		"extra.page_faults":                             4,
+		"extra.heap_usage":                              4096,
+		"extra.is_master":                               1,
+		"global_lock.total_time":                        1000,
+		"global_lock.queued_writers":                    2,
+		"global_lock.active_total":                      9,
+		"ops.inserts":                                   10,
+		"ops.getmores":                                  11,
+		"metrics.commands.find.total":                   12,
+		"metrics.cursor.timedout":                       1,
+		"metrics.cursor.open-pinned":                    2,
+		"wiredtiger.cache.bytes_currently_in_the_cache": 2048,
+		"wiredtiger.conc_txn_rd.available":              128,
+	}
+	for name, want := range expected {
+		if got, ok := sink.gauges[name]; !ok || got != want {
+			t.Errorf("%s: expected %d, got %d (present: %v)", name, want, got, ok)
+		}
+	}
+	if _, ok := sink.gauges["metrics.commands.drop.total"]; ok {
+		t.Error("expected commands that never ran to be skipped")
+	}
+}
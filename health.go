@@ -0,0 +1,61 @@
+package mgostatsd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HealthHandler serves the /healthz, /readyz and /status pages for the pollers of a Runner
+type HealthHandler struct {
+	runner         *Runner
+	readyIntervals int
+	mux            *http.ServeMux
+}
+
+// NewHealthHandler creates a HealthHandler, /readyz fails until a target was polled successfully
+// within readyIntervals polling intervals
+func NewHealthHandler(runner *Runner, readyIntervals int) *HealthHandler {
+	h := &HealthHandler{runner: runner, readyIntervals: readyIntervals, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/healthz", h.healthz)
+	h.mux.HandleFunc("/readyz", h.readyz)
+	h.mux.HandleFunc("/status", h.status)
+	return h
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Register adds the pages to mux, for sharing a listener with the Prometheus exporter
+func (h *HealthHandler) Register(mux *http.ServeMux) {
+	mux.Handle("/healthz", h)
+	mux.Handle("/readyz", h)
+	mux.Handle("/status", h)
+}
+
+func (h *HealthHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *HealthHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	if !h.runner.Ready(h.readyIntervals, time.Now()) {
+		http.Error(w, fmt.Sprintf("no target polled successfully within the last %d intervals", h.readyIntervals),
+			http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *HealthHandler) status(w http.ResponseWriter, r *http.Request) {
+	body, err := json.MarshalIndent(struct {
+		Targets []PollerStatus `json:"targets"`
+	}{h.runner.Status()}, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,75 @@
+package mgostatsd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	runner := NewRunner(nil)
+	defer runner.Stop()
+	if err := runner.Apply(testRunnerConfig(Config{Name: "orders", Mongo: Mongo{Addresses: []string{"127.0.0.1:1"}}})); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	handler := NewHealthHandler(runner, 3)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("/healthz: expected 200, got %d", w.Code)
+	}
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz: expected 503 before any poll, got %d", w.Code)
+	}
+
+	poller := runner.Pools()[0].Pollers()[0]
+	poller.mu.Lock()
+	poller.lastSuccess = time.Now().Add(-2 * time.Second)
+	poller.version = "3.6.4"
+	poller.mu.Unlock()
+	poller.recordError(errors.New("command serverStatus requires authentication"))
+
+	if w := get("/readyz"); w.Code != http.StatusOK {
+		t.Errorf("/readyz: expected 200 after a recent poll, got %d", w.Code)
+	}
+	if runner.Ready(3, time.Now().Add(time.Minute)) {
+		t.Error("expected not to be ready once the last poll is older than 3 intervals")
+	}
+
+	w := get("/status")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("/status: unexpected response %d %v", w.Code, w.Header())
+	}
+	var page struct {
+		Targets []PollerStatus `json:"targets"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("/status: invalid JSON: %v", err)
+	}
+	if len(page.Targets) != 1 {
+		t.Fatalf("/status: expected 1 target, got %+v", page.Targets)
+	}
+	status := page.Targets[0]
+	if status.Target != "orders" || status.Address != "127.0.0.1:1" || status.Version != "3.6.4" ||
+		status.LastScrape == nil || status.LastError == "" {
+		t.Errorf("/status: unexpected target %+v", status)
+	}
+
+	// the next successful cycle clears the error
+	poller.recordSuccess(time.Now(), "3.6.4", nil)
+	if status := poller.Status(); status.LastError != "" {
+		t.Errorf("expected the error to be cleared by a successful cycle, got %q", status.LastError)
+	}
+	poller.recordSuccess(time.Now(), "3.6.4", errors.New("not authorized on admin"))
+	if status := poller.Status(); status.LastError != "not authorized on admin" {
+		t.Errorf("expected the collector error of the cycle, got %q", status.LastError)
+	}
+}
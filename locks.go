@@ -0,0 +1,117 @@
+package mgostatsd
+
+import "time"
+
+type LockModes struct {
+	IntentShared    int64 `bson:"r" metric:"intent_shared"`
+	IntentExclusive int64 `bson:"w" metric:"intent_exclusive"`
+	Shared          int64 `bson:"R" metric:"shared"`
+	Exclusive       int64 `bson:"W" metric:"exclusive"`
+}
+
+type LockStats struct {
+	AcquireCount        LockModes `bson:"acquireCount" metric:"acquire_count,counter"`
+	AcquireWaitCount    LockModes `bson:"acquireWaitCount" metric:"acquire_wait_count,counter"`
+	TimeAcquiringMicros LockModes `bson:"timeAcquiringMicros" metric:"time_acquiring_micros,counter"`
+}
+
+type Locks struct {
+	Global     *LockStats `bson:"Global" metric:"global"`
+	Database   *LockStats `bson:"Database" metric:"database"`
+	Collection *LockStats `bson:"Collection" metric:"collection"`
+	Metadata   *LockStats `bson:"Metadata" metric:"metadata"`
+	Oplog      *LockStats `bson:"oplog" metric:"oplog"`
+}
+
+// lockType is a lock type of the locks section with its metric name
+type lockType struct {
+	name  string
+	stats *LockStats
+}
+
+// types returns the lock types present in the response
+func (l *Locks) types() []lockType {
+	var types []lockType
+	for _, t := range []lockType{
+		{"global", l.Global},
+		{"database", l.Database},
+		{"collection", l.Collection},
+		{"metadata", l.Metadata},
+		{"oplog", l.Oplog},
+	} {
+		if t.stats != nil {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// lockMode holds the counters of one lock mode with its metric name
+type lockMode struct {
+	name                      string
+	acquire, wait, waitMicros int64
+}
+
+// modes returns the counters of the r, w, R and W modes
+func (s *LockStats) modes() []lockMode {
+	return []lockMode{
+		{"intent_shared", s.AcquireCount.IntentShared, s.AcquireWaitCount.IntentShared, s.TimeAcquiringMicros.IntentShared},
+		{"intent_exclusive", s.AcquireCount.IntentExclusive, s.AcquireWaitCount.IntentExclusive, s.TimeAcquiringMicros.IntentExclusive},
+		{"shared", s.AcquireCount.Shared, s.AcquireWaitCount.Shared, s.TimeAcquiringMicros.Shared},
+		{"exclusive", s.AcquireCount.Exclusive, s.AcquireWaitCount.Exclusive, s.TimeAcquiringMicros.Exclusive},
+	}
+}
+
+// LockTracker keeps the previous locks sample of one polled address, so lock contention can be
+// emitted per interval
+type LockTracker struct {
+	prev     map[string]LockStats
+	restarts restartDetector
+}
+
+// NewLockTracker creates a tracker without a previous sample
+func NewLockTracker() *LockTracker {
+	return &LockTracker{prev: make(map[string]LockStats)}
+}
+
+// Write writes, for every lock type and mode acquired since the previous sample, the percentage of
+// acquisitions that had to wait as locks.<type>.wait_ratio_percent.<mode> and the average time
+// those waited as the timing locks.<type>.avg_wait.<mode>
+func (t *LockTracker) Write(sink Sink, locks *Locks, uptime int64) error {
+	if t.restarts.restarted(uptime) {
+		t.prev = make(map[string]LockStats)
+	}
+	if locks == nil {
+		return nil
+	}
+
+	for _, lock := range locks.types() {
+		prev, ok := t.prev[lock.name]
+		t.prev[lock.name] = *lock.stats
+		if !ok {
+			continue
+		}
+
+		prevModes := prev.modes()
+		for i, mode := range lock.stats.modes() {
+			acquired := mode.acquire - prevModes[i].acquire
+			waited := mode.wait - prevModes[i].wait
+			waitMicros := mode.waitMicros - prevModes[i].waitMicros
+			if acquired <= 0 || waited < 0 || waitMicros < 0 {
+				continue // not acquired during the interval, or the counters were reset
+			}
+
+			err := sink.GaugeFloat("locks."+lock.name+".wait_ratio_percent."+mode.name, float64(waited)*100/float64(acquired))
+			if err != nil {
+				return err
+			}
+			if waited > 0 {
+				err = sink.Timing("locks."+lock.name+".avg_wait."+mode.name, time.Duration(waitMicros/waited)*time.Microsecond)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
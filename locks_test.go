@@ -0,0 +1,67 @@
+package mgostatsd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockTracker(t *testing.T) {
+	tracker := NewLockTracker()
+	sink := newRecordingSink()
+
+	tracker.Write(sink, &Locks{
+		Global: &LockStats{
+			AcquireCount:        LockModes{IntentShared: 1000, Exclusive: 10},
+			AcquireWaitCount:    LockModes{Exclusive: 2},
+			TimeAcquiringMicros: LockModes{Exclusive: 500},
+		},
+	}, 100)
+	if len(sink.floats) > 0 || len(sink.timings) > 0 {
+		t.Error("expected nothing on the first sample")
+	}
+
+	err := tracker.Write(sink, &Locks{
+		Global: &LockStats{
+			AcquireCount:        LockModes{IntentShared: 1400, Exclusive: 30},
+			AcquireWaitCount:    LockModes{Exclusive: 7},
+			TimeAcquiringMicros: LockModes{Exclusive: 4500},
+		},
+		Database: &LockStats{AcquireCount: LockModes{IntentShared: 5}},
+	}, 105)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := sink.floats["locks.global.wait_ratio_percent.exclusive"]; v != 25 {
+		t.Errorf("expected 25%% of exclusive acquisitions to wait, got %v", v)
+	}
+	if v, ok := sink.floats["locks.global.wait_ratio_percent.intent_shared"]; !ok || v != 0 {
+		t.Errorf("expected no intent shared waits, got %v", v)
+	}
+	if v := sink.timings["locks.global.avg_wait.exclusive"]; v != 800*time.Microsecond {
+		t.Errorf("expected an average exclusive wait of 800us, got %v", v)
+	}
+	if _, ok := sink.timings["locks.global.avg_wait.intent_shared"]; ok {
+		t.Error("expected no average wait without waits")
+	}
+	if _, ok := sink.floats["locks.global.wait_ratio_percent.shared"]; ok {
+		t.Error("expected no ratio for a mode not acquired during the interval")
+	}
+	if _, ok := sink.floats["locks.database.wait_ratio_percent.intent_shared"]; ok {
+		t.Error("expected no ratio without a previous sample")
+	}
+
+	// contention well under 1% is still visible
+	tracker.Write(sink, &Locks{
+		Global: &LockStats{
+			AcquireCount:        LockModes{IntentShared: 3400, Exclusive: 30},
+			AcquireWaitCount:    LockModes{IntentShared: 1, Exclusive: 7},
+			TimeAcquiringMicros: LockModes{IntentShared: 50, Exclusive: 4500},
+		},
+	}, 110)
+	if v := sink.floats["locks.global.wait_ratio_percent.intent_shared"]; v != 0.05 {
+		t.Errorf("expected 0.05%% of intent shared acquisitions to wait, got %v", v)
+	}
+	if !IsCounter("locks.global.time_acquiring_micros.exclusive") || IsCounter("locks.global.wait_ratio_percent.exclusive") {
+		t.Error("expected only the raw lock counters to be counters")
+	}
+}
@@ -1,128 +1,222 @@
 package mgostatsd
 
 import (
+	"crypto/tls"
 	"fmt"
-	"regexp"
+	"net"
 	str "strings"
 	"time"
 
 	"github.com/cactus/go-statsd-client/statsd"
 	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
 )
 
 type Connections struct {
-	Current      int64 `metric:"current"`
-	Available    int64 `metric:"available"`
-	TotalCreated int64 `metric:"totalCreated"`
+	Current      int64 `bson:"current" metric:"current"`
+	Available    int64 `bson:"available" metric:"available"`
+	TotalCreated int64 `bson:"totalCreated" metric:"created,counter"`
+}
+
+type Asserts struct {
+	Regular   int64 `bson:"regular" metric:"regular"`
+	Warning   int64 `bson:"warning" metric:"warning"`
+	Msg       int64 `bson:"msg" metric:"msg"`
+	User      int64 `bson:"user" metric:"user"`
+	Rollovers int64 `bson:"rollovers" metric:"rollovers"`
+}
+
+type CompressionBytes struct {
+	BytesIn  int64 `bson:"bytesIn" metric:"bytes_in"`
+	BytesOut int64 `bson:"bytesOut" metric:"bytes_out"`
+}
+
+type CompressionStats struct {
+	Compressor   CompressionBytes `bson:"compressor" metric:"compressor"`
+	Decompressor CompressionBytes `bson:"decompressor" metric:"decompressor"`
+}
+
+type Network struct {
+	BytesIn          int64                       `bson:"bytesIn" metric:"bytes_in"`
+	BytesOut         int64                       `bson:"bytesOut" metric:"bytes_out"`
+	PhysicalBytesIn  int64                       `bson:"physicalBytesIn" metric:"physical_bytes_in"`
+	PhysicalBytesOut int64                       `bson:"physicalBytesOut" metric:"physical_bytes_out"`
+	NumRequests      int64                       `bson:"numRequests" metric:"requests"`
+	Compression      map[string]CompressionStats `bson:"compression" metric:"compression"`
 }
 
 type Mem struct {
-	Resident          int64 `metric:"resident"`
-	Virtual           int64 `metric:"virtual"`
-	Mapped            int64 `metric:"mapped"`
-	MappedWithJournal int64 `metric:"mappedWithJournal"`
+	Resident          int64 `bson:"resident" metric:"resident"`
+	Virtual           int64 `bson:"virtual" metric:"virtual"`
+	Mapped            int64 `bson:"mapped" metric:"mapped"`
+	MappedWithJournal int64 `bson:"mappedWithJournal" metric:"mapped_with_journal"`
+}
+
+type TcmallocGeneric struct {
+	CurrentAllocatedBytes int64 `bson:"current_allocated_bytes" metric:"current_allocated_bytes"`
+	HeapSize              int64 `bson:"heap_size" metric:"heap_size"`
+}
+
+type TcmallocStats struct {
+	PageheapFreeBytes            int64 `bson:"pageheap_free_bytes" metric:"pageheap_free_bytes"`
+	PageheapUnmappedBytes        int64 `bson:"pageheap_unmapped_bytes" metric:"pageheap_unmapped_bytes"`
+	MaxTotalThreadCacheBytes     int64 `bson:"max_total_thread_cache_bytes" metric:"max_total_thread_cache_bytes"`
+	CurrentTotalThreadCacheBytes int64 `bson:"current_total_thread_cache_bytes" metric:"current_total_thread_cache_bytes"`
+	TotalFreeBytes               int64 `bson:"total_free_bytes" metric:"total_free_bytes"`
+	CentralCacheFreeBytes        int64 `bson:"central_cache_free_bytes" metric:"central_cache_free_bytes"`
+	TransferCacheFreeBytes       int64 `bson:"transfer_cache_free_bytes" metric:"transfer_cache_free_bytes"`
+	ThreadCacheFreeBytes         int64 `bson:"thread_cache_free_bytes" metric:"thread_cache_free_bytes"`
+	AggressiveMemoryDecommit     int64 `bson:"aggressive_memory_decommit" metric:"aggressive_memory_decommit"`
+}
+
+type Tcmalloc struct {
+	Generic  TcmallocGeneric `bson:"generic" metric:"generic"`
+	Tcmalloc TcmallocStats   `bson:"tcmalloc" metric:"tcmalloc"`
 }
 
 type RWT struct {
-	Readers int64 `metric:"readers"`
-	Writers int64 `metric:"writers"`
-	Total   int64 `metric:"total"`
+	Readers int64 `bson:"readers" metric:"readers"`
+	Writers int64 `bson:"writers" metric:"writers"`
+	Total   int64 `bson:"total" metric:"total"`
 }
 
 type GlobalLock struct {
-	TotalTime     int64 `metric:"totalTime"`
-	LockTime      int64 `metric:"lockTime"`
-	CurrentQueue  RWT   `metric:"currentQueue"`
-	ActiveClients RWT   `metric:"activeClients"`
+	TotalTime     int64 `bson:"totalTime" metric:"total_time,counter"`
+	LockTime      int64 `bson:"lockTime" metric:"lock_time,counter"`
+	CurrentQueue  RWT   `bson:"currentQueue" metric:"queued_"`
+	ActiveClients RWT   `bson:"activeClients" metric:"active_"`
 }
 
 type Opcounters struct {
-	Insert  int64 `metric:"insert"`
-	Query   int64 `metric:"query"`
-	Update  int64 `metric:"update"`
-	Delete  int64 `metric:"delete"`
-	GetMore int64 `metric:"getmore"`
-	Command int64 `metric:"command"`
+	Insert  int64 `bson:"insert" metric:"inserts"`
+	Query   int64 `bson:"query" metric:"queries"`
+	Update  int64 `bson:"update" metric:"updates"`
+	Delete  int64 `bson:"delete" metric:"deletes"`
+	GetMore int64 `bson:"getmore" metric:"getmores"`
+	Command int64 `bson:"command" metric:"commands"`
 }
 
 type ExtraInfo struct {
-	PageFaults       int64 `metric:"page_faults"`
-	HeapUsageInBytes int64 `metric:"heap_usage_bytes"`
+	PageFaults       int64 `bson:"page_faults" metric:"page_faults,counter"`
+	HeapUsageInBytes int64 `bson:"heap_usage_bytes" metric:"heap_usage"`
 }
 
 type ReplicaInfo struct {
-	IsMaster  bool `metric:"ismaster"`
-	Secondary bool `metric:"secondary"`
+	SetName     string `bson:"setName"`
+	IsMaster    bool   `bson:"ismaster" metric:"is_master"`
+	Secondary   bool   `bson:"secondary" metric:"is_secondary"`
+	ArbiterOnly bool   `bson:"arbiterOnly"`
 }
 
 type CommandCounter struct {
-	Failed int64 `metric:"failed"`
-	Total  int64 `metric:"total"`
+	Failed int64 `bson:"failed" metric:"failed"`
+	Total  int64 `bson:"total" metric:"total"`
 }
 
 type CursorMetrics struct {
-	TimedOut int64            `metric:"timedOut"`
-	Open     map[string]int64 `metric:"open"`
+	TimedOut int64            `bson:"timedOut" metric:"timedout,counter"`
+	Open     map[string]int64 `bson:"open" metric:"open-"`
 }
 
 type ServerMetrics struct {
-	Commands      map[string]CommandCounter `metric:"commands"`
-	Cursor        CursorMetrics             `metric:"cursor"`
-	Document      map[string]int64          `metric:"document"`
-	Operation     map[string]int64          `metric:"operation"`
-	QueryExecutor map[string]int64          `metric:"queryExecutor"`
+	Commands      map[string]CommandCounter `bson:"commands" metric:"commands,omitempty,counter"`
+	Cursor        CursorMetrics             `bson:"cursor" metric:"cursor"`
+	Document      map[string]int64          `bson:"document" metric:"document,counter"`
+	Operation     map[string]int64          `bson:"operation" metric:"operation,counter"`
+	QueryExecutor map[string]int64          `bson:"queryExecutor" metric:"query_executor,counter"`
 }
 
 type ConcurrentTransactionsInfo struct {
-	Write map[string]int64 `metric:"write"`
-	Read  map[string]int64 `metric:"read"`
+	Write map[string]int64 `bson:"write" metric:"wr"`
+	Read  map[string]int64 `bson:"read" metric:"rd"`
 }
 
+// WiredTigerInfo mixes point in time values and cumulative counters in its sections, the
+// counter option lists the keys of the counters
 type WiredTigerInfo struct {
-	Cache                  map[string]int64           `metric:"cache"`
-	Connection             map[string]int64           `metric:"connection"`
-	ConcurrentTransactions ConcurrentTransactionsInfo `metric:"concurrentTransactions"`
+	Cache                  map[string]int64           `bson:"cache" metric:"cache,counter=bytes_read_into_cache|bytes_written_from_cache|pages_read_into_cache|pages_written_from_cache|pages_requested_from_the_cache|*pages_evicted*"`
+	Connection             map[string]int64           `bson:"connection" metric:"conn,counter=total_*|memory_*"`
+	ConcurrentTransactions ConcurrentTransactionsInfo `bson:"concurrentTransactions" metric:"conc_txn_"`
+	Transaction            map[string]int64           `bson:"transaction" metric:"txn,counter=transaction_begins|transaction_checkpoints|transaction_checkpoint_total_time*|transactions_committed|transactions_rolled_back"`
+	BlockManager           map[string]int64           `bson:"block-manager" metric:"block_manager,counter"`
+	Log                    map[string]int64           `bson:"log" metric:"log,counter=log_bytes_*|log_records_*|log_sync*|*_operations"`
+	Cursor                 map[string]int64           `bson:"cursor" metric:"cursor,counter=*calls*"`
+	Session                map[string]int64           `bson:"session" metric:"session,counter=*calls*"`
+	DataHandle             map[string]int64           `bson:"data-handle" metric:"data_handle,counter=connection_sweep*|session_*"`
 }
 
 type ServerStatus struct {
-	Host                 string              `metric:"host"`
-	Version              string              `metric:"version"`
-	Process              string              `metric:"process"`
-	Pid                  int64               `metric:"pid"`
-	Uptime               int64               `metric:"uptime"`
-	UptimeInMillis       int64               `metric:"uptimeMillis"`
-	UptimeEstimate       int64               `metric:"uptimeEstimate"`
-	LocalTime            bson.MongoTimestamp `metric:"localTime"`
-	Connections          Connections         `metric:"connections"`
-	ExtraInfo            ExtraInfo           `metric:"extra_info"`
-	Mem                  Mem                 `metric:"mem"`
-	GlobalLocks          GlobalLock          `metric:"globalLock"`
-	Opcounters           Opcounters          `metric:"opcounters"`
-	OpcountersReplicaSet Opcounters          `metric:"opcountersRepl"`
-	ReplicaSet           ReplicaInfo         `metric:"repl"`
-	Metrics              ServerMetrics       `metric:"metrics"`
-	WiredTiger           *WiredTigerInfo     `metric:"wiredTiger"`
+	Host                 string          `bson:"host"`
+	Version              string          `bson:"version"`
+	Process              string          `bson:"process"`
+	Pid                  int64           `bson:"pid"`
+	Uptime               int64           `bson:"uptime" metric:"uptime"`
+	UptimeInMillis       int64           `bson:"uptimeMillis"`
+	UptimeEstimate       int64           `bson:"uptimeEstimate"`
+	LocalTime            time.Time       `bson:"localTime"`
+	Connections          Connections     `bson:"connections" metric:"connections"`
+	Asserts              Asserts         `bson:"asserts" metric:"asserts,counter,rate"`
+	Network              Network         `bson:"network" metric:"network,counter,rate"`
+	ExtraInfo            ExtraInfo       `bson:"extra_info" metric:"extra"`
+	Mem                  Mem             `bson:"mem" metric:"mem"`
+	Tcmalloc             *Tcmalloc       `bson:"tcmalloc" metric:"tcmalloc"`
+	GlobalLocks          GlobalLock      `bson:"globalLock" metric:"global_lock"`
+	Locks                *Locks          `bson:"locks" metric:"locks"`
+	Opcounters           Opcounters      `bson:"opcounters" metric:"ops,counter"`
+	OpcountersReplicaSet Opcounters      `bson:"opcountersRepl" metric:"ops_repl,counter"`
+	ReplicaSet           ReplicaInfo     `bson:"repl" metric:"extra"`
+	Metrics              ServerMetrics   `bson:"metrics" metric:"metrics"`
+	WiredTiger           *WiredTigerInfo `bson:"wiredTiger" metric:"wiredtiger"`
+	OpLatencies          *OpLatencies    `bson:"opLatencies" metric:"op_latencies"`
 }
 
 // GetSession creates and configures a new mgo.Session
 func GetSession(mongoConfig Mongo, server string) (*mgo.Session, error) {
 	dialInfo := mgo.DialInfo{
-		Addrs:   []string{server},
-		Direct:  true,
-		Timeout: time.Second * 5,
+		Addrs:          []string{server},
+		Direct:         true,
+		Timeout:        time.Second * 5,
+		ReplicaSetName: mongoConfig.ReplicaSet,
+	}
+	if mongoConfig.Timeout > 0 {
+		dialInfo.Timeout = mongoConfig.Timeout
 	}
 	if len(mongoConfig.User) > 0 {
 		dialInfo.Username = mongoConfig.User
 		dialInfo.Password = mongoConfig.Pass
 		dialInfo.Source = mongoConfig.AuthDb
+		dialInfo.Mechanism = mongoConfig.Mechanism
+	}
+	if err := mongoConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if mongoConfig.TLS.Enabled {
+		tlsConfig, err := mongoConfig.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+		dialInfo.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.DialWithDialer(&net.Dialer{Timeout: dialInfo.Timeout}, "tcp", addr.String(), tlsConfig)
+		}
+
+		if mongoConfig.Mechanism == MechanismX509 {
+			dialInfo.Mechanism = MechanismX509
+			dialInfo.Source = "$external"
+			dialInfo.Username = mongoConfig.User
+			if dialInfo.Username == "" {
+				dialInfo.Username, err = x509Username(tlsConfig)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
 	}
 	session, err := mgo.DialWithInfo(&dialInfo)
 	if err != nil {
 		return nil, err
 	}
 
-	// Optional. Switch the session to a monotonic behavior.
+	// Sessions are direct to a single member, a read preference would make them fail on the
+	// members it excludes, so every member is read regardless of its state
 	session.SetMode(mgo.Monotonic, true)
 
 	return session, nil
@@ -130,266 +224,102 @@ func GetSession(mongoConfig Mongo, server string) (*mgo.Session, error) {
 
 // GetServerStatus returns a struct of the MongoDB 'serverStatus' command response
 func GetServerStatus(session *mgo.Session) (*ServerStatus, error) {
-	var s *ServerStatus
-	err := session.Run("serverStatus", &s)
-	return s, err
+	return getServerStatus(session, false)
 }
 
-func pushConnections(client statsd.Statter, connections Connections) error {
-	var err error
-	// Connections
-	err = client.Gauge("connections.current", int64(connections.Current), 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("connections.available", int64(connections.Available), 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("connections.created", int64(connections.TotalCreated), 1.0)
-	if err != nil {
-		return err
-	}
-
-	return nil
+// GetServerStatusWithHistograms is GetServerStatus including the opLatencies histograms
+func GetServerStatusWithHistograms(session *mgo.Session) (*ServerStatus, error) {
+	return getServerStatus(session, true)
 }
 
-func pushOpcounters(client statsd.Statter, opscounters Opcounters) error {
-	var err error
-
-	// Ops Counters (non-RS)
-	err = client.Gauge("ops.inserts", opscounters.Insert, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("ops.queries", opscounters.Query, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("ops.updates", opscounters.Update, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("ops.deletes", opscounters.Delete, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("ops.getmores", opscounters.GetMore, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("ops.commands", opscounters.Command, 1.0)
-	if err != nil {
-		return err
-	}
-
-	return nil
+func getServerStatus(session *mgo.Session, histograms bool) (*ServerStatus, error) {
+	var s *ServerStatus
+	err := session.Run(serverStatusCommand(histograms), &s)
+	return s, err
 }
 
-func pushMem(client statsd.Statter, mem Mem) error {
-	var err error
-
-	err = client.Gauge("mem.resident", mem.Resident, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("mem.virtual", mem.Virtual, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("mem.mapped", mem.Mapped, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("mem.mapped_with_journal", mem.MappedWithJournal, 1.0)
-	if err != nil {
-		return err
+// StatsdPrefix builds the env.cluster.host prefix for metrics of the given mongo host
+func StatsdPrefix(statsdConfig Statsd, host string) string {
+	prefix := statsdConfig.Env
+	if len(statsdConfig.Cluster) > 0 {
+		prefix = fmt.Sprintf("%s.%s", prefix, statsdConfig.Cluster)
 	}
-
-	return nil
+	return fmt.Sprintf("%s.%s", prefix, metricHost(host))
 }
 
-func pushGlobalLocks(client statsd.Statter, glob GlobalLock) error {
-	var err error
-
-	err = client.Gauge("global_lock.total_time", glob.TotalTime, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("global_lock.lock_time", glob.LockTime, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("global_lock.active_readers", glob.ActiveClients.Readers, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("global_lock.active_writers", glob.ActiveClients.Writers, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("global_lock.active_total", glob.ActiveClients.Total, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("global_lock.queued_readers", glob.CurrentQueue.Readers, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("global_lock.queued_writers", glob.CurrentQueue.Writers, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("global_lock.queued_total", glob.CurrentQueue.Total, 1.0)
-	if err != nil {
-		return err
-	}
-
-	return nil
+// metricHost turns a host:port into a single metric path component
+func metricHost(host string) string {
+	return str.Replace(str.Replace(host, ":", "-", -1), ".", "_", -1)
 }
 
-func pushExtraInfo(client statsd.Statter, info ExtraInfo, rinfo ReplicaInfo) error {
-	var err error
-
-	err = client.Gauge("extra.page_faults", info.PageFaults, 1.0)
-	if err != nil {
-		return err
-	}
-
-	err = client.Gauge("extra.heap_usage", info.HeapUsageInBytes, 1.0)
-	if err != nil {
-		return err
-	}
-
-	if rinfo.IsMaster {
-		err = client.Gauge("extra.is_master", 1, 1.0)
-	} else {
-		err = client.Gauge("extra.is_master", 0, 1.0)
-	}
-	if err != nil {
-		return err
-	}
-
-	if rinfo.Secondary {
-		err = client.Gauge("extra.is_secondary", 1, 1.0)
-	} else {
-		err = client.Gauge("extra.is_secondary", 0, 1.0)
-	}
-	if err != nil {
-		return err
-	}
-
-	return nil
+// State returns the role of the server: mongos, standalone, primary, secondary, arbiter or other
+func (s *ServerStatus) State() string {
+	switch {
+	case s.Process == "mongos":
+		return "mongos"
+	case s.ReplicaSet.SetName == "":
+		return "standalone"
+	case s.ReplicaSet.IsMaster:
+		return "primary"
+	case s.ReplicaSet.Secondary:
+		return "secondary"
+	case s.ReplicaSet.ArbiterOnly:
+		return "arbiter"
+	}
+	return "other"
 }
 
-func pushMetrics(client statsd.Statter, serverMetrics ServerMetrics) error {
-	var err error
-	for k, v := range serverMetrics.Commands {
-		if v.Failed > 0 || v.Total > 0 {
-			err = client.Gauge(fmt.Sprintf("metrics.commands.%s.%s", k, "failed"), v.Failed, 1.0)
-			if err != nil {
-				return err
-			}
-			err = client.Gauge(fmt.Sprintf("metrics.commands.%s.%s", k, "total"), v.Total, 1.0)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	err = client.Gauge("metrics.cursor.timedout", serverMetrics.Cursor.TimedOut, 1.0)
-	if err != nil {
-		return err
-	}
-
-	for k, v := range serverMetrics.Cursor.Open {
-		err = client.Gauge(fmt.Sprintf("metrics.cursor.open-%s", k), v, 1.0)
-		if err != nil {
-			return err
-		}
-	}
-
-	for k, v := range serverMetrics.Document {
-		err = client.Gauge(fmt.Sprintf("metrics.document.%s", k), v, 1.0)
-		if err != nil {
-			return err
-		}
-	}
-	for k, v := range serverMetrics.Operation {
-		err = client.Gauge(fmt.Sprintf("metrics.operation.%s", k), v, 1.0)
-		if err != nil {
-			return err
-		}
-	}
-
-	for k, v := range serverMetrics.QueryExecutor {
-		err = client.Gauge(fmt.Sprintf("metrics.query_executor.%s", k), v, 1.0)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+// StatusTags returns the env, cluster, host, replset, state and version tags describing status,
+// followed by the static tags from statsdConfig
+func StatusTags(statsdConfig Statsd, status *ServerStatus) []Tag {
+	tags := []Tag{
+		{Key: "env", Value: statsdConfig.Env},
+		{Key: "cluster", Value: statsdConfig.Cluster},
+		{Key: "host", Value: status.Host},
+	}
+	if status.ReplicaSet.SetName != "" {
+		tags = append(tags, Tag{Key: "replset", Value: status.ReplicaSet.SetName})
+	}
+	tags = append(tags,
+		Tag{Key: "state", Value: status.State()},
+		Tag{Key: "version", Value: status.Version},
+	)
+	return append(tags, statsdConfig.Tags...)
 }
 
-var badMetricChars = regexp.MustCompile("[^-a-zA-Z_]+")
-
-func pushWTInfo(client statsd.Statter, wtinfo *WiredTigerInfo) error {
-	var err error
-	if wtinfo == nil {
-		return nil // WiredTiger not enabled
-	}
-	for k, v := range wtinfo.Cache {
-		cleanKey := badMetricChars.ReplaceAllLiteralString(k, "_") //str.Replace(k," ","_",-1)
-		err = client.Gauge(fmt.Sprintf("wiredtiger.cache.%s", cleanKey), v, 1.0)
+// DialStatsd opens a StatsD sink for the metrics of status. Metric names are prefixed with
+// env.cluster.host, or in DogStatsD mode with the fixed Prefix and tagged with StatusTags instead.
+func DialStatsd(statsdConfig Statsd, status *ServerStatus) (Sink, error) {
+	hostPort := fmt.Sprintf("%s:%d", statsdConfig.Host, statsdConfig.Port)
+	if !statsdConfig.DogStatsd {
+		client, err := statsd.NewClient(hostPort, StatsdPrefix(statsdConfig, status.Host))
 		if err != nil {
-			return err
+			return nil, err
 		}
-
+		return OwnClient(NewStatsdSink(client), client), nil
 	}
 
-	for k, v := range wtinfo.ConcurrentTransactions.Read {
-		cleanKey := badMetricChars.ReplaceAllLiteralString(k, "_")
-		err = client.Gauge(fmt.Sprintf("wiredtiger.conc_txn_rd.%s", cleanKey), v, 1.0)
-		if err != nil {
-			return err
-		}
+	client, err := statsd.NewClient(hostPort, statsdConfig.Prefix)
+	if err != nil {
+		return nil, err
 	}
+	return WithTags(OwnClient(NewDogStatsdSink(client), client), StatusTags(statsdConfig, status)...), nil
+}
 
-	for k, v := range wtinfo.ConcurrentTransactions.Write {
-		cleanKey := badMetricChars.ReplaceAllLiteralString(k, "_")
-		err = client.Gauge(fmt.Sprintf("wiredtiger.conc_txn_wr.%s", cleanKey), v, 1.0)
-		if err != nil {
-			return err
-		}
-	}
+// NewStatsdClient creates the long lived StatsD client shared by every polled address. It batches
+// metrics into packets of up to MaxPacketSize bytes, sent at least every FlushInterval.
+func NewStatsdClient(statsdConfig Statsd) (statsd.Statter, error) {
+	hostPort := fmt.Sprintf("%s:%d", statsdConfig.Host, statsdConfig.Port)
+	return statsd.NewBufferedClient(hostPort, "", statsdConfig.FlushInterval, statsdConfig.MaxPacketSize)
+}
 
-	for k, v := range wtinfo.Connection {
-		cleanKey := badMetricChars.ReplaceAllLiteralString(k, "_")
-		err = client.Gauge(fmt.Sprintf("wiredtiger.conn.%s", cleanKey), v, 1.0)
-		if err != nil {
-			return err
-		}
+// StatsdSinkFor returns a sink writing the metrics of status through a client created with NewStatsdClient,
+// named and tagged like DialStatsd. Closing the sink leaves the shared client open.
+func StatsdSinkFor(client statsd.Statter, statsdConfig Statsd, status *ServerStatus) Sink {
+	if !statsdConfig.DogStatsd {
+		return NewStatsdSink(client.NewSubStatter(StatsdPrefix(statsdConfig, status.Host)))
 	}
-
-	return nil
+	return WithTags(NewDogStatsdSink(client.NewSubStatter(statsdConfig.Prefix)), StatusTags(statsdConfig, status)...)
 }
 
 // PushStats pushes the metrics in the provided ServerStatus struct to StatsD
@@ -397,52 +327,22 @@ func PushStats(statsdConfig Statsd, status *ServerStatus, verbose bool) error {
 	if status == nil {
 		return nil // This means we didn't connect, so lets silently skip this cycle
 	}
-	prefix := statsdConfig.Env
-	if len(statsdConfig.Cluster) > 0 {
-		prefix = fmt.Sprintf("%s.%s", prefix, statsdConfig.Cluster)
-	}
-	prefix = fmt.Sprintf("%s.%s", prefix, str.Replace(str.Replace(status.Host, ":", "-", -1), ".", "_", -1))
-	hostPort := fmt.Sprintf("%s:%d", statsdConfig.Host, statsdConfig.Port)
-	client, err := statsd.NewClient(hostPort, prefix)
-	if err != nil {
-		return err
-	}
-	defer client.Close()
-
-	err = pushConnections(client, status.Connections)
-	if err != nil {
-		return err
-	}
-
-	err = pushOpcounters(client, status.Opcounters)
-	if err != nil {
-		return err
-	}
-
-	err = pushMem(client, status.Mem)
-	if err != nil {
-		return err
-	}
-
-	err = pushGlobalLocks(client, status.GlobalLocks)
-	if err != nil {
-		return err
-	}
-
-	err = pushExtraInfo(client, status.ExtraInfo, status.ReplicaSet)
+	sink, err := DialStatsd(statsdConfig, status)
 	if err != nil {
 		return err
 	}
+	defer sink.Close()
 
-	err = pushMetrics(client, status.Metrics)
-	if err != nil {
-		return err
-	}
+	return WriteStats(sink, status)
+}
 
-	err = pushWTInfo(client, status.WiredTiger)
-	if err != nil {
-		return err
+// WriteStats writes every metric tagged in the provided ServerStatus to sink
+func WriteStats(sink Sink, status *ServerStatus) error {
+	for _, metric := range Flatten("", status) {
+		err := sink.Gauge(metric.Name, metric.Value)
+		if err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
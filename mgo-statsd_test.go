@@ -45,5 +45,12 @@ func TestServerStatusFixtures(t *testing.T) {
 		if status.Opcounters.Command < 1 {
 			t.Errorf("status.Opcounters.Command is < 1: %v", status.Opcounters.Command)
 		}
+
+		// Network
+		if status.Network.NumRequests < 1 {
+			t.Errorf("status.Network.NumRequests is < 1: %v", status.Network.NumRequests)
+		} else if status.Network.BytesOut < 1 {
+			t.Errorf("status.Network.BytesOut is < 1: %v", status.Network.BytesOut)
+		}
 	}
 }
@@ -0,0 +1,114 @@
+package mgostatsd
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type LatencyBucket struct {
+	Micros int64 `bson:"micros"`
+	Count  int64 `bson:"count"`
+}
+
+type OpLatency struct {
+	Latency   int64           `bson:"latency" metric:"latency,counter"`
+	Ops       int64           `bson:"ops" metric:"ops,counter"`
+	Histogram []LatencyBucket `bson:"histogram"`
+}
+
+type OpLatencies struct {
+	Reads        *OpLatency `bson:"reads" metric:"reads"`
+	Writes       *OpLatency `bson:"writes" metric:"writes"`
+	Commands     *OpLatency `bson:"commands" metric:"commands"`
+	Transactions *OpLatency `bson:"transactions" metric:"transactions"`
+}
+
+// opClass is an op class of opLatencies with its metric name
+type opClass struct {
+	name    string
+	latency *OpLatency
+}
+
+// classes returns the op classes present in the response
+func (l *OpLatencies) classes() []opClass {
+	var classes []opClass
+	for _, class := range []opClass{
+		{"reads", l.Reads},
+		{"writes", l.Writes},
+		{"commands", l.Commands},
+		{"transactions", l.Transactions},
+	} {
+		if class.latency != nil {
+			classes = append(classes, class)
+		}
+	}
+	return classes
+}
+
+// serverStatusCommand returns the 'serverStatus' command, asking for the opLatencies histograms if histograms is set
+func serverStatusCommand(histograms bool) interface{} {
+	if !histograms {
+		return "serverStatus"
+	}
+	return bson.D{{Name: "serverStatus", Value: 1}, {Name: "opLatencies", Value: bson.M{"histograms": true}}}
+}
+
+// LatencyTracker keeps the previous opLatencies sample of one polled address, so average latencies
+// and histogram buckets can be emitted per interval
+type LatencyTracker struct {
+	prev     map[string]OpLatency
+	restarts restartDetector
+}
+
+// NewLatencyTracker creates a tracker without a previous sample
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{prev: make(map[string]OpLatency)}
+}
+
+// Write writes the average latency of every op class since the previous sample as a timing named
+// op_latencies.<class>.avg, and the number of operations that fell in every histogram bucket as a
+// counter named op_latencies.<class>.histogram.<micros>us
+func (t *LatencyTracker) Write(sink Sink, latencies *OpLatencies, uptime int64) error {
+	if t.restarts.restarted(uptime) {
+		t.prev = make(map[string]OpLatency)
+	}
+	if latencies == nil {
+		return nil
+	}
+
+	for _, c := range latencies.classes() {
+		name, class := c.name, c.latency
+		prev, ok := t.prev[name]
+		t.prev[name] = *class
+		if !ok || class.Ops < prev.Ops || class.Latency < prev.Latency {
+			continue // nothing to compare against yet, or the counters were reset
+		}
+
+		prefix := "op_latencies." + name
+		if ops := class.Ops - prev.Ops; ops > 0 {
+			avg := time.Duration((class.Latency-prev.Latency)/ops) * time.Microsecond
+			err := sink.Timing(prefix+".avg", avg)
+			if err != nil {
+				return err
+			}
+		}
+
+		counts := make(map[int64]int64, len(prev.Histogram))
+		for _, bucket := range prev.Histogram {
+			counts[bucket.Micros] = bucket.Count
+		}
+		for _, bucket := range class.Histogram {
+			count := bucket.Count - counts[bucket.Micros]
+			if count < 0 {
+				continue
+			}
+			err := sink.Counter(fmt.Sprintf("%s.histogram.%dus", prefix, bucket.Micros), count)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
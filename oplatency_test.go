@@ -0,0 +1,92 @@
+package mgostatsd
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	tracker := NewLatencyTracker()
+	sink := newRecordingSink()
+
+	err := tracker.Write(sink, &OpLatencies{
+		Reads: &OpLatency{Latency: 1000, Ops: 10, Histogram: []LatencyBucket{{Micros: 64, Count: 8}, {Micros: 128, Count: 2}}},
+	}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.timings) > 0 || len(sink.counters) > 0 {
+		t.Error("expected nothing on the first sample")
+	}
+
+	err = tracker.Write(sink, &OpLatencies{
+		Reads:  &OpLatency{Latency: 5000, Ops: 30, Histogram: []LatencyBucket{{Micros: 64, Count: 20}, {Micros: 128, Count: 9}, {Micros: 256, Count: 1}}},
+		Writes: &OpLatency{Latency: 700, Ops: 2},
+	}, 105)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if avg := sink.timings["op_latencies.reads.avg"]; avg != 200*time.Microsecond {
+		t.Errorf("expected an average read latency of 200us, got %v", avg)
+	}
+	for name, expected := range map[string]int64{
+		"op_latencies.reads.histogram.64us":  12,
+		"op_latencies.reads.histogram.128us": 7,
+		"op_latencies.reads.histogram.256us": 1,
+	} {
+		if sink.counters[name] != expected {
+			t.Errorf("expected %s to be %d, got %d", name, expected, sink.counters[name])
+		}
+	}
+	if _, ok := sink.timings["op_latencies.writes.avg"]; ok {
+		t.Error("expected no write latency without a previous sample")
+	}
+
+	// mongod restarted, the counters start over
+	sink = newRecordingSink()
+	tracker.Write(sink, &OpLatencies{Reads: &OpLatency{Latency: 100, Ops: 1}}, 3)
+	if len(sink.timings) > 0 {
+		t.Errorf("expected no timings across a restart, got %v", sink.timings)
+	}
+}
+
+func TestOpLatenciesDecode(t *testing.T) {
+	data, err := bson.Marshal(bson.M{
+		"uptime": 10,
+		"opLatencies": bson.M{
+			"reads":    bson.M{"latency": int64(2500), "ops": int64(5), "histogram": []bson.M{{"micros": int64(512), "count": int64(5)}}},
+			"writes":   bson.M{"latency": int64(0), "ops": int64(0)},
+			"commands": bson.M{"latency": int64(900), "ops": int64(3)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status ServerStatus
+	if err := bson.Unmarshal(data, &status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.OpLatencies == nil || status.OpLatencies.Reads == nil {
+		t.Fatal("expected opLatencies to be decoded")
+	}
+	if h := status.OpLatencies.Reads.Histogram; len(h) != 1 || h[0].Micros != 512 || h[0].Count != 5 {
+		t.Errorf("unexpected histogram %v", h)
+	}
+	if status.OpLatencies.Transactions != nil {
+		t.Error("expected no transactions on a server without them")
+	}
+
+	metrics := make(map[string]int64)
+	for _, metric := range Flatten("", &status) {
+		metrics[metric.Name] = metric.Value
+	}
+	if metrics["op_latencies.reads.latency"] != 2500 || metrics["op_latencies.commands.ops"] != 3 {
+		t.Errorf("unexpected flattened metrics %v", metrics)
+	}
+	if !IsCounter("op_latencies.reads.latency") || !IsCounter("op_latencies.writes.ops") {
+		t.Error("expected opLatencies totals to be counters")
+	}
+}
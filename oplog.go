@@ -0,0 +1,146 @@
+package mgostatsd
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type OplogStats struct {
+	Size        int64 `bson:"size"`
+	StorageSize int64 `bson:"storageSize"`
+	MaxSize     int64 `bson:"maxSize"`
+	Count       int64 `bson:"count"`
+}
+
+type OplogInfo struct {
+	First bson.MongoTimestamp
+	Last  bson.MongoTimestamp
+	Stats OplogStats
+}
+
+type oplogEntry struct {
+	Ts bson.MongoTimestamp `bson:"ts"`
+}
+
+// timestampSeconds returns the seconds since the epoch held in the high 32 bits of a MongoTimestamp
+func timestampSeconds(ts bson.MongoTimestamp) int64 {
+	return int64(ts) >> 32
+}
+
+// Window returns the number of seconds between the first and last oplog entries
+func (o *OplogInfo) Window() int64 {
+	return timestampSeconds(o.Last) - timestampSeconds(o.First)
+}
+
+// GetOplogInfo reads the first and last entry timestamps and the collStats of local.oplog.rs.
+// It returns mgo.ErrNotFound when the server has no oplog.
+func GetOplogInfo(session *mgo.Session) (*OplogInfo, error) {
+	var first, last oplogEntry
+	oplog := session.DB("local").C("oplog.rs")
+
+	err := oplog.Find(nil).Sort("$natural").Select(bson.M{"ts": 1}).One(&first)
+	if err != nil {
+		return nil, err
+	}
+	err = oplog.Find(nil).Sort("-$natural").Select(bson.M{"ts": 1}).One(&last)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &OplogInfo{First: first.Ts, Last: last.Ts}
+	err = session.DB("local").Run(bson.D{{Name: "collStats", Value: "oplog.rs"}}, &info.Stats)
+	return info, err
+}
+
+func pushOplogInfo(sink Sink, info *OplogInfo) error {
+	var err error
+
+	window := info.Window()
+	err = sink.Gauge("oplog.window_seconds", window)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge("oplog.max_size", info.Stats.MaxSize)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge("oplog.size", info.Stats.Size)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge("oplog.storage_size", info.Stats.StorageSize)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge("oplog.count", info.Stats.Count)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// churnBytesPerHour estimates the bytes written to the oplog per hour between the samples prev and
+// cur, taken elapsed apart. While the oplog grows its used size grows by the bytes written. Once it
+// is full the oldest entries are truncated as new ones are written, so the time truncated from its
+// start is converted into bytes with the average bytes per second of oplog in prev. It returns
+// false without a previous sample or when the oplog was dropped and recreated in between.
+func churnBytesPerHour(prev, cur *OplogInfo, elapsed time.Duration) (int64, bool) {
+	if prev == nil || elapsed <= 0 || cur.Last < prev.Last || cur.First < prev.First {
+		return 0, false
+	}
+	written := cur.Stats.Size - prev.Stats.Size
+	if truncated := timestampSeconds(cur.First) - timestampSeconds(prev.First); truncated > 0 && prev.Window() > 0 {
+		written += truncated * prev.Stats.Size / prev.Window()
+	}
+	if written < 0 {
+		written = 0 // compacted
+	}
+	return int64(float64(written) * float64(time.Hour) / float64(elapsed)), true
+}
+
+// OplogCollector emits the oplog window, its configured and used size and the churn rate since
+// the previous sample
+type OplogCollector struct {
+	prev     *OplogInfo
+	prevTime time.Time
+}
+
+func (c *OplogCollector) Collect(session *mgo.Session, sink Sink) error {
+	isMaster, err := GetIsMaster(session)
+	if err != nil {
+		return err
+	}
+	if isMaster.SetName == "" {
+		return nil // a mongos or a standalone, neither has an oplog
+	}
+
+	info, err := GetOplogInfo(session)
+	if err == mgo.ErrNotFound || isCommandUnsupported(err) {
+		return nil // the oplog is empty
+	}
+	if err != nil {
+		return err
+	}
+	err = pushOplogInfo(sink, info)
+	if err != nil {
+		return err
+	}
+	return c.pushChurn(sink, info, time.Now())
+}
+
+// pushChurn emits the churn rate since the previous sample, taken at now
+func (c *OplogCollector) pushChurn(sink Sink, info *OplogInfo, now time.Time) error {
+	churn, ok := churnBytesPerHour(c.prev, info, now.Sub(c.prevTime))
+	c.prev, c.prevTime = info, now
+	if !ok {
+		return nil
+	}
+	return sink.Gauge("oplog.churn_bytes_per_hour", churn)
+}
@@ -0,0 +1,73 @@
+package mgostatsd
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPushOplogInfo(t *testing.T) {
+	info := &OplogInfo{
+		First: bson.MongoTimestamp(1500000000<<32 | 1),
+		Last:  bson.MongoTimestamp(1500007200<<32 | 5),
+		Stats: OplogStats{Size: 1 << 30, MaxSize: 2 << 30, StorageSize: 1 << 29, Count: 1000},
+	}
+
+	sink := newRecordingSink()
+	if err := pushOplogInfo(sink, info); err != nil {
+		t.Fatalf("pushOplogInfo failed: %v", err)
+	}
+
+	expected := map[string]int64{
+		"oplog.window_seconds": 7200,
+		"oplog.max_size":       2 << 30,
+		"oplog.size":           1 << 30,
+	}
+	for name, want := range expected {
+		if got := sink.gauges[name]; got != want {
+			t.Errorf("%s: expected %d, got %d", name, want, got)
+		}
+	}
+}
+
+func oplogSample(first, last, size int64) *OplogInfo {
+	return &OplogInfo{
+		First: bson.MongoTimestamp(first << 32),
+		Last:  bson.MongoTimestamp(last << 32),
+		Stats: OplogStats{Size: size},
+	}
+}
+
+func TestOplogChurn(t *testing.T) {
+	collector := &OplogCollector{}
+	start := time.Now()
+	sink := newRecordingSink()
+
+	collector.pushChurn(sink, oplogSample(1000, 2000, 100<<20), start)
+	if _, ok := sink.gauges["oplog.churn_bytes_per_hour"]; ok {
+		t.Error("expected no churn without a previous sample")
+	}
+
+	// a growing oplog churns as fast as its size grows
+	collector.pushChurn(sink, oplogSample(1000, 2060, 160<<20), start.Add(time.Minute))
+	if got := sink.gauges["oplog.churn_bytes_per_hour"]; got != 3600<<20 {
+		t.Errorf("expected %d bytes per hour, got %d", int64(3600<<20), got)
+	}
+
+	// a full oplog churns as fast as its oldest entries are truncated, 10h of oplog in 1GiB,
+	// 6 minutes of it truncated in a minute
+	collector = &OplogCollector{}
+	collector.pushChurn(sink, oplogSample(1000, 37000, 1<<30), start)
+	collector.pushChurn(sink, oplogSample(1360, 37060, 1<<30), start.Add(time.Minute))
+	if got := sink.gauges["oplog.churn_bytes_per_hour"]; got != 644245080 {
+		t.Errorf("expected 644245080 bytes per hour, got %d", got)
+	}
+
+	// the oplog was recreated, nothing to compare against
+	sink = newRecordingSink()
+	collector.pushChurn(sink, oplogSample(5, 10, 1<<10), start.Add(2*time.Minute))
+	if _, ok := sink.gauges["oplog.churn_bytes_per_hour"]; ok {
+		t.Error("expected no churn across a recreated oplog")
+	}
+}
@@ -0,0 +1,475 @@
+package mgostatsd
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cactus/go-statsd-client/statsd"
+	"github.com/kr/pretty"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Target is an address to poll, with the shard it belongs to when discovered in a sharded cluster
+type Target struct {
+	Address string
+	Shard   string
+}
+
+// AddressTargets returns a Target without shard for each address
+func AddressTargets(addresses []string) []Target {
+	targets := make([]Target, 0, len(addresses))
+	for _, address := range addresses {
+		targets = append(targets, Target{Address: address})
+	}
+	return targets
+}
+
+// String formats the target as shard/address, or just the address outside a sharded cluster
+func (t Target) String() string {
+	if t.Shard == "" {
+		return t.Address
+	}
+	return t.Shard + "/" + t.Address
+}
+
+// Outputs are the destinations the metrics of every polled address are written to
+type Outputs struct {
+	Statsd     statsd.Statter
+	Prometheus *PrometheusExporter
+
+	statsdConfig Statsd
+}
+
+// NewOutputs opens the StatsD client enabled in config, metrics are also written to exporter unless it is nil
+func NewOutputs(config Config, exporter *PrometheusExporter) (*Outputs, error) {
+	outputs := &Outputs{Prometheus: exporter, statsdConfig: config.Statsd}
+	if config.Statsd.Enabled {
+		client, err := NewStatsdClient(config.Statsd)
+		if err != nil {
+			return nil, err
+		}
+		outputs.Statsd = client
+	}
+	return outputs, nil
+}
+
+// SinkFor returns a sink writing the metrics of status, polled from target, to every output.
+// The metrics of a shard member are tagged with the shard, or in plain StatsD named
+// env.cluster.shard.host. Counters are converted by counters for StatsD only, Prometheus
+// computes rates itself from the cumulative values.
+func (o *Outputs) SinkFor(target Target, status *ServerStatus, counters *CounterTracker) MultiSink {
+	var shardTags []Tag
+	statsdConfig := o.statsdConfig
+	if target.Shard != "" {
+		shardTags = []Tag{{Key: "shard", Value: target.Shard}}
+		if !statsdConfig.DogStatsd {
+			statsdConfig.Cluster = joinMetric(statsdConfig.Cluster, metricComponent(target.Shard))
+		}
+	}
+
+	var sinks MultiSink
+	if o.Prometheus != nil {
+		sink := o.Prometheus.ClusterSink(target.Address, status.Host, o.statsdConfig.Cluster)
+		sinks = append(sinks, WithTags(sink, shardTags...))
+	}
+	if o.Statsd != nil {
+		sink := StatsdSinkFor(o.Statsd, statsdConfig, status)
+		if statsdConfig.DogStatsd {
+			sink = WithTags(sink, shardTags...)
+		}
+		if counters != nil {
+			sink = counters.Wrap(sink, status.Uptime, time.Now())
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks
+}
+
+// Remove drops the last metrics of server from outputs that keep them
+func (o *Outputs) Remove(server string) {
+	if o.Prometheus != nil {
+		o.Prometheus.Remove(server)
+	}
+}
+
+// Close flushes and closes the shared StatsD client
+func (o *Outputs) Close() error {
+	if o.Statsd != nil {
+		return o.Statsd.Close()
+	}
+	return nil
+}
+
+// Poller collects the metrics of a single address on every interval and writes them to the outputs of its pool
+type Poller struct {
+	Server string
+	Shard  string
+
+	config     Config
+	pool       *Pool
+	supervisor *Supervisor
+	collectors []Collector
+	counters   *CounterTracker
+	latencies  *LatencyTracker
+	locks      *LockTracker
+
+	lastCycle time.Time
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastErr     error
+	version     string
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// newPoller creates a Poller for target, it starts polling once Start is called
+func newPoller(config Config, pool *Pool, target Target) (*Poller, error) {
+	collectors, err := NewCollectors(config)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := NewCounterTracker(config.Counters)
+	if err != nil {
+		return nil, err
+	}
+	p := &Poller{
+		Server:     target.Address,
+		Shard:      target.Shard,
+		config:     config,
+		pool:       pool,
+		supervisor: NewSupervisor(config.Mongo, target.Address, config.Reconnect),
+		collectors: collectors,
+		counters:   counters,
+		latencies:  NewLatencyTracker(),
+		locks:      NewLockTracker(),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	p.supervisor.OnDialError = p.dialFailed
+	return p, nil
+}
+
+// Target returns the address and shard polled
+func (p *Poller) Target() Target {
+	return Target{Address: p.Server, Shard: p.Shard}
+}
+
+// State returns the connection state of the poller, see Supervisor.State
+func (p *Poller) State() (ConnState, time.Time, error) {
+	return p.supervisor.State()
+}
+
+func (p *Poller) recordError(err error) {
+	p.mu.Lock()
+	p.lastErr = err
+	p.mu.Unlock()
+}
+
+// recordSuccess records a cycle completed at now, replacing the last error with the error of a
+// collector during the cycle, nil when they all succeeded, so the status page doesn't keep
+// reporting a recovered failure
+func (p *Poller) recordSuccess(now time.Time, version string, err error) {
+	p.mu.Lock()
+	p.lastSuccess = now
+	p.version = version
+	p.lastErr = err
+	p.mu.Unlock()
+}
+
+// PollerStatus describes the state of a poller for the status page
+type PollerStatus struct {
+	Target     string     `json:"target"`
+	Address    string     `json:"address"`
+	Shard      string     `json:"shard,omitempty"`
+	State      string     `json:"state"`
+	Since      time.Time  `json:"since"`
+	LastScrape *time.Time `json:"last_scrape,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	Version    string     `json:"version,omitempty"`
+}
+
+// Status returns the connection state, time of the last successful cycle, last error and MongoDB version
+func (p *Poller) Status() PollerStatus {
+	state, since, _ := p.State()
+	status := PollerStatus{
+		Address: p.Server,
+		Shard:   p.Shard,
+		State:   state.String(),
+		Since:   since,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.lastSuccess.IsZero() {
+		lastScrape := p.lastSuccess
+		status.LastScrape = &lastScrape
+	}
+	if p.lastErr != nil {
+		status.LastError = p.lastErr.Error()
+	}
+	status.Version = p.version
+	return status
+}
+
+// Start polls in a new goroutine until Stop is called
+func (p *Poller) Start() {
+	go func() {
+		defer close(p.done)
+		p.supervisor.Run(p.quit, p.config.Interval, p.collect)
+	}()
+}
+
+// Stop stops polling, closes the session and waits for the current cycle to finish
+func (p *Poller) Stop() {
+	close(p.quit)
+	<-p.done
+	p.pool.Outputs().Remove(p.Server)
+}
+
+func (p *Poller) collect(session *mgo.Session) error {
+	// outputs may be swapped by a reload, hold on to the current ones for the whole cycle
+	p.pool.cycles.RLock()
+	defer p.pool.cycles.RUnlock()
+	outputs := p.pool.Outputs()
+
+	self := outputs.TargetSelfSink(p.Target())
+	defer self.Close()
+	defer self.Flush()
+
+	start := time.Now()
+	if !p.lastCycle.IsZero() {
+		// the ticker drops ticks while a cycle runs late, count the ones lost since the previous cycle
+		interval := p.config.Interval
+		if skipped := int64((start.Sub(p.lastCycle)+interval/2)/interval) - 1; skipped > 0 {
+			self.Counter("skipped_ticks", skipped)
+		}
+	}
+	p.lastCycle = start
+
+	if p.config.Verbose {
+		log.Printf("[%v] Starting stats\n", p.Server)
+	}
+
+	var (
+		status *ServerStatus
+		raw    bson.M
+		err    error
+	)
+	if p.config.Raw.Enabled {
+		status, raw, err = getServerStatusRaw(session, p.config.LatencyHistograms)
+	} else {
+		status, err = getServerStatus(session, p.config.LatencyHistograms)
+	}
+	if err != nil {
+		log.Printf("[%v] Error running 'serverStatus' command: %v\n", p.Server, err)
+		self.Counter("errors."+ErrorServerStatus, 1)
+		p.recordError(err)
+		outputs.Remove(p.Server)
+		return err
+	}
+	self.Timing("serverstatus_rtt", time.Since(start))
+	if p.config.Verbose {
+		log.Println(pretty.Sprintf("Mongo ServerStatus: \n%v\n", status))
+	}
+
+	sinks := outputs.SinkFor(p.Target(), status, p.counters)
+	defer sinks.Close()
+	sink := &countingSink{Sink: sinks}
+
+	if raw != nil {
+		err = WriteRawStats(sink, raw, p.config.Raw.Filter)
+	} else {
+		err = WriteStats(sink, status)
+	}
+	if err == nil {
+		err = WriteDerivedStats(sink, status)
+	}
+	if err == nil {
+		err = p.latencies.Write(sink, status.OpLatencies, status.Uptime)
+	}
+	if err == nil {
+		err = p.locks.Write(sink, status.Locks, status.Uptime)
+	}
+	if err != nil {
+		log.Printf("[%v] ERROR: %v\n", p.Server, err)
+		self.Counter("errors."+ErrorWrite, 1)
+	}
+	var collectorErr error
+	for _, collector := range p.collectors {
+		err = collector.Collect(session, sink)
+		if IsNetworkError(err) {
+			self.Counter("errors."+ErrorCollector, 1)
+			p.recordError(err)
+			return err
+		}
+		if err != nil {
+			log.Printf("[%v] ERROR: %v\n", p.Server, err)
+			self.Counter("errors."+ErrorCollector, 1)
+			collectorErr = err
+		}
+	}
+	err = sinks.Flush()
+	if err != nil {
+		log.Printf("[%v] ERROR: %v\n", p.Server, err)
+		self.Counter("errors."+ErrorPush, 1)
+	}
+
+	now := time.Now()
+	self.Gauge("metrics", sink.count)
+	self.Gauge("last_success", now.Unix())
+	p.recordSuccess(now, status.Version, collectorErr)
+	if p.config.Verbose {
+		log.Printf("[%v] Done pushing stats\n", p.Server)
+	}
+	return nil
+}
+
+// dialFailed counts a failed connection attempt in the self metrics
+func (p *Poller) dialFailed(err error) {
+	p.recordError(err)
+	p.pool.cycles.RLock()
+	defer p.pool.cycles.RUnlock()
+	self := p.pool.Outputs().TargetSelfSink(p.Target())
+	self.Counter("errors."+ErrorConnect, 1)
+	self.Flush()
+	self.Close()
+}
+
+// Pool runs one Poller per target, starting and stopping them as the set of targets changes
+type Pool struct {
+	outputs atomic.Value
+	cycles  sync.RWMutex
+
+	mu      sync.Mutex
+	config  Config
+	pollers map[string]*Poller
+}
+
+// NewPool creates an empty Pool, targets are added with Sync
+func NewPool(config Config, outputs *Outputs) *Pool {
+	p := &Pool{
+		config:  config,
+		pollers: make(map[string]*Poller),
+	}
+	p.outputs.Store(outputs)
+	return p
+}
+
+// Outputs returns the outputs pollers currently write to
+func (p *Pool) Outputs() *Outputs {
+	return p.outputs.Load().(*Outputs)
+}
+
+// SetOutputs atomically replaces the outputs and returns the previous ones once no poller uses
+// them anymore, so they can be closed without losing metrics
+func (p *Pool) SetOutputs(outputs *Outputs) *Outputs {
+	prev := p.Outputs()
+	p.outputs.Store(outputs)
+	p.cycles.Lock()
+	p.cycles.Unlock()
+	return prev
+}
+
+// Reconfigure changes the configuration new pollers are created with. When a setting used by
+// pollers changed every poller is stopped, the following Sync restarts them. It returns whether
+// pollers were stopped.
+func (p *Pool) Reconfigure(config Config) bool {
+	p.mu.Lock()
+	changed := !reflect.DeepEqual(pollerSettings(p.config), pollerSettings(config))
+	p.config = config
+	var stopped []*Poller
+	if changed {
+		for server, poller := range p.pollers {
+			stopped = append(stopped, poller)
+			delete(p.pollers, server)
+		}
+	}
+	p.mu.Unlock()
+
+	stopPollers(stopped)
+	return changed
+}
+
+// stopPollers stops pollers, which waits for a dial or a cycle in progress, so it's called
+// without holding the lock Pollers needs
+func stopPollers(pollers []*Poller) {
+	for _, poller := range pollers {
+		poller.Stop()
+	}
+}
+
+// pollerSettings returns config without the settings that don't affect a running poller,
+// the addresses are handled by Sync and the outputs by SetOutputs
+func pollerSettings(config Config) Config {
+	config.Name = ""
+	config.Targets = nil
+	config.Mongo.Addresses = nil
+	config.Discovery = Discovery{}
+	config.Statsd = Statsd{}
+	config.Prometheus = Prometheus{}
+	config.Health = Health{}
+	return config
+}
+
+// Sync starts pollers for targets not yet polled and stops the ones for targets no longer listed,
+// a target that moved to another shard is restarted. It returns the added and removed targets.
+func (p *Pool) Sync(targets []Target) (added, removed []Target, err error) {
+	wanted := make(map[string]Target, len(targets))
+	for _, target := range targets {
+		wanted[target.Address] = target
+	}
+	var stopped []*Poller
+	p.mu.Lock()
+	for server, poller := range p.pollers {
+		if target, ok := wanted[server]; ok && target == poller.Target() {
+			continue
+		}
+		stopped = append(stopped, poller)
+		delete(p.pollers, server)
+		removed = append(removed, poller.Target())
+	}
+	p.mu.Unlock()
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Address < removed[j].Address })
+	stopPollers(stopped)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, target := range targets {
+		if _, ok := p.pollers[target.Address]; ok {
+			continue
+		}
+		poller, err := newPoller(p.config, p, target)
+		if err != nil {
+			return added, removed, err
+		}
+		poller.Start()
+		p.pollers[target.Address] = poller
+		added = append(added, target)
+	}
+	return added, removed, nil
+}
+
+// Pollers returns the running pollers ordered by address
+func (p *Pool) Pollers() []*Poller {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pollers := make([]*Poller, 0, len(p.pollers))
+	for _, poller := range p.pollers {
+		pollers = append(pollers, poller)
+	}
+	sort.Slice(pollers, func(i, j int) bool { return pollers[i].Server < pollers[j].Server })
+	return pollers
+}
+
+// Stop stops every poller
+func (p *Pool) Stop() {
+	p.Sync(nil)
+}
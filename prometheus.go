@@ -0,0 +1,241 @@
+package mgostatsd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	str "strings"
+	"sync"
+	"time"
+)
+
+type promSeries struct {
+	name   string
+	labels string
+}
+
+// promTarget holds the metrics of one polled address. Counters are the sums of the increases
+// written with Counter, totals the cumulative counters of mongod written as gauges, both are
+// exposed as Prometheus counters.
+type promTarget struct {
+	host     string
+	cluster  string
+	gauges   map[promSeries]float64
+	counters map[promSeries]float64
+	totals   map[promSeries]float64
+}
+
+func newPromTarget(host, cluster string) promTarget {
+	return promTarget{
+		host:     host,
+		cluster:  cluster,
+		gauges:   make(map[promSeries]float64),
+		counters: make(map[promSeries]float64),
+		totals:   make(map[promSeries]float64),
+	}
+}
+
+// PrometheusExporter serves the latest metrics of every polled address in the
+// Prometheus text exposition format. Host, cluster and env are exposed as labels.
+type PrometheusExporter struct {
+	env     string
+	cluster string
+
+	mu      sync.RWMutex
+	targets map[string]promTarget
+}
+
+// NewPrometheusExporter creates an exporter labelling metrics with the env and cluster of statsdConfig
+func NewPrometheusExporter(statsdConfig Statsd) *PrometheusExporter {
+	return &PrometheusExporter{
+		env:     statsdConfig.Env,
+		cluster: statsdConfig.Cluster,
+		targets: make(map[string]promTarget),
+	}
+}
+
+// Sink returns a Sink for the given server address, flushing it replaces the gauges
+// exposed for server and adds to its counters
+func (e *PrometheusExporter) Sink(server, host string) Sink {
+	return e.ClusterSink(server, host, e.cluster)
+}
+
+// ClusterSink is Sink for a server labelled with its own cluster instead of the exporter's
+func (e *PrometheusExporter) ClusterSink(server, host, cluster string) Sink {
+	return &promSink{exporter: e, server: server, target: newPromTarget(host, cluster)}
+}
+
+// Update replaces the metrics exposed for server with the ones in status
+func (e *PrometheusExporter) Update(server string, status *ServerStatus) error {
+	if status == nil {
+		return nil
+	}
+	sink := e.Sink(server, status.Host)
+	err := WriteStats(sink, status)
+	if err != nil {
+		return err
+	}
+	return sink.Flush()
+}
+
+// Remove stops exposing metrics for server, so an unreachable address doesn't report stale values
+func (e *PrometheusExporter) Remove(server string) {
+	e.mu.Lock()
+	delete(e.targets, server)
+	e.mu.Unlock()
+}
+
+func (e *PrometheusExporter) publish(server string, target promTarget) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if prev, ok := e.targets[server]; ok {
+		for series, value := range prev.counters {
+			target.counters[series] += value
+		}
+	}
+	e.targets[server] = target
+}
+
+var badPromChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+
+// promName converts a dotted StatsD metric name into a Prometheus one
+func promName(stat string) string {
+	return "mongodb_" + badPromChars.ReplaceAllLiteralString(stat, "_")
+}
+
+// promCounterName converts a dotted StatsD counter name into a Prometheus one ending in _total
+func promCounterName(stat string) string {
+	name := promName(stat)
+	if str.HasSuffix(name, "_total") {
+		return name
+	}
+	return name + "_total"
+}
+
+var promLabelEscaper = str.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabels(tags []Tag) string {
+	labels := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`,
+			badPromChars.ReplaceAllLiteralString(tag.Key, "_"), promLabelEscaper.Replace(tag.Value)))
+	}
+	sort.Strings(labels)
+	return str.Join(labels, ",")
+}
+
+// labels returns the host, cluster and env labels of every series of t
+func (t promTarget) labels(env string) string {
+	return fmt.Sprintf(`host="%s",cluster="%s",env="%s"`,
+		promLabelEscaper.Replace(t.host),
+		promLabelEscaper.Replace(t.cluster),
+		promLabelEscaper.Replace(env))
+}
+
+type promSample struct {
+	labels string
+	value  float64
+}
+
+// Render writes all exposed metrics to buf. A gauge sharing its name with a counter, which
+// Prometheus would reject, is exposed with a _gauge suffix.
+func (e *PrometheusExporter) Render(buf *bytes.Buffer) {
+	samples := make(map[string][]promSample)
+	types := make(map[string]string)
+
+	add := func(base, name, kind string, series promSeries, value float64) {
+		labels := base
+		if series.labels != "" {
+			labels += "," + series.labels
+		}
+		types[name] = kind
+		samples[name] = append(samples[name], promSample{labels: labels, value: value})
+	}
+
+	e.mu.RLock()
+	for _, target := range e.targets {
+		base := target.labels(e.env)
+		for _, counters := range []map[promSeries]float64{target.counters, target.totals} {
+			for series, value := range counters {
+				add(base, series.name, "counter", series, value)
+			}
+		}
+	}
+	for _, target := range e.targets {
+		base := target.labels(e.env)
+		for series, value := range target.gauges {
+			name := series.name
+			if types[name] == "counter" {
+				name += "_gauge"
+			}
+			add(base, name, "gauge", series, value)
+		}
+	}
+	e.mu.RUnlock()
+
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		series := samples[name]
+		sort.Slice(series, func(i, j int) bool { return series[i].labels < series[j].labels })
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, types[name])
+		for _, sample := range series {
+			fmt.Fprintf(buf, "%s{%s} %s\n", name, sample.labels, strconv.FormatFloat(sample.value, 'g', -1, 64))
+		}
+	}
+}
+
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	e.Render(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// promSink collects one cycle of metrics for a target and publishes them on Flush
+type promSink struct {
+	exporter *PrometheusExporter
+	server   string
+	target   promTarget
+}
+
+func (s *promSink) Gauge(name string, value int64, tags ...Tag) error {
+	if IsCounter(name) {
+		s.target.totals[promSeries{name: promCounterName(name), labels: promLabels(tags)}] = float64(value)
+		return nil
+	}
+	s.target.gauges[promSeries{name: promName(name), labels: promLabels(tags)}] = float64(value)
+	return nil
+}
+
+func (s *promSink) GaugeFloat(name string, value float64, tags ...Tag) error {
+	s.target.gauges[promSeries{name: promName(name), labels: promLabels(tags)}] = value
+	return nil
+}
+
+func (s *promSink) Counter(name string, value int64, tags ...Tag) error {
+	s.target.counters[promSeries{name: promCounterName(name), labels: promLabels(tags)}] += float64(value)
+	return nil
+}
+
+func (s *promSink) Timing(name string, value time.Duration, tags ...Tag) error {
+	s.target.gauges[promSeries{name: promName(name) + "_seconds", labels: promLabels(tags)}] = value.Seconds()
+	return nil
+}
+
+func (s *promSink) Flush() error {
+	s.exporter.publish(s.server, s.target)
+	s.target = newPromTarget(s.target.host, s.target.cluster)
+	return nil
+}
+
+func (s *promSink) Close() error {
+	return nil
+}
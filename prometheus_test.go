@@ -0,0 +1,74 @@
+package mgostatsd
+
+import (
+	"bytes"
+	str "strings"
+	"testing"
+)
+
+func TestPrometheusExporterRender(t *testing.T) {
+	exporter := NewPrometheusExporter(Statsd{Env: "prod", Cluster: "main"})
+	status := &ServerStatus{
+		Host:        "db1.example.com:27017",
+		Connections: Connections{Current: 12, Available: 800},
+	}
+	if err := exporter.Update("db1:27017", status); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	exporter.Render(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE mongodb_connections_current gauge\n",
+		`mongodb_connections_current{host="db1.example.com:27017",cluster="main",env="prod"} 12` + "\n",
+		`mongodb_connections_available{host="db1.example.com:27017",cluster="main",env="prod"} 800` + "\n",
+	} {
+		if !str.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	exporter.Remove("db1:27017")
+	buf.Reset()
+	exporter.Render(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("expected no output after Remove, got:\n%s", buf.String())
+	}
+}
+
+func TestPrometheusCounterTypes(t *testing.T) {
+	exporter := NewPrometheusExporter(Statsd{Env: "prod", Cluster: "main"})
+	sink := exporter.Sink("db1:27017", "db1:27017")
+	sink.Gauge("ops.inserts", 40)
+	sink.Counter("migrations", 1)
+	sink.Flush()
+
+	sink.Gauge("ops.inserts", 45)
+	sink.Gauge("metrics.commands.find.total", 7)
+	sink.Counter("migrations", 2)
+	sink.Gauge("migrations_total", 5)
+	sink.Flush()
+
+	var buf bytes.Buffer
+	exporter.Render(&buf)
+	out := buf.String()
+	labels := `{host="db1:27017",cluster="main",env="prod"}`
+	for _, want := range []string{
+		"# TYPE mongodb_ops_inserts_total counter\n",
+		"mongodb_ops_inserts_total" + labels + " 45\n",
+		"# TYPE mongodb_metrics_commands_find_total counter\n",
+		"mongodb_metrics_commands_find_total" + labels + " 7\n",
+		"# TYPE mongodb_migrations_total counter\n",
+		"mongodb_migrations_total" + labels + " 3\n",
+		"# TYPE mongodb_migrations_total_gauge gauge\n",
+		"mongodb_migrations_total_gauge" + labels + " 5\n",
+	} {
+		if !str.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if str.Contains(out, "mongodb_ops_inserts ") || str.Contains(out, "mongodb_ops_inserts{") {
+		t.Errorf("expected the cumulative counter not to be exposed as a gauge, got:\n%s", out)
+	}
+}
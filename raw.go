@@ -0,0 +1,48 @@
+package mgostatsd
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// GetServerStatusRaw returns the MongoDB 'serverStatus' command response decoded both into a
+// ServerStatus and into a bson.M holding every field, including those ServerStatus doesn't know
+func GetServerStatusRaw(session *mgo.Session) (*ServerStatus, bson.M, error) {
+	return getServerStatusRaw(session, false)
+}
+
+// getServerStatusRaw is GetServerStatusRaw, including the opLatencies histograms if histograms is set
+func getServerStatusRaw(session *mgo.Session, histograms bool) (*ServerStatus, bson.M, error) {
+	var raw bson.Raw
+	err := session.Run(serverStatusCommand(histograms), &raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status := &ServerStatus{}
+	err = raw.Unmarshal(status)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := bson.M{}
+	err = raw.Unmarshal(&fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return status, fields, nil
+}
+
+// WriteRawStats writes every numeric leaf of a raw serverStatus passing filter to sink,
+// named by the path of serverStatus fields leading to it
+func WriteRawStats(sink Sink, fields bson.M, filter Filter) error {
+	for _, metric := range Flatten("", fields) {
+		if !filter.Match(metric.Name) {
+			continue
+		}
+		err := sink.Gauge(metric.Name, metric.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package mgostatsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestWriteRawStats(t *testing.T) {
+	fields := bson.M{
+		"host":     "db1:27017",
+		"ok":       1.0,
+		"asserts":  bson.M{"regular": 1, "warning": 2},
+		"network":  bson.M{"bytesIn": int64(1000), "compression": bson.M{"snappy": bson.M{"compressor": bson.M{"bytesIn": 10}}}},
+		"security": bson.M{"SSLServerHasCertificateAuthority": false},
+	}
+
+	sink := newRecordingSink()
+	filter := Filter{Exclude: []string{"ok", "network.compression.*"}}
+	if err := WriteRawStats(sink, fields, filter); err != nil {
+		t.Fatalf("WriteRawStats failed: %v", err)
+	}
+
+	expected := map[string]int64{
+		"asserts.regular": 1,
+		"asserts.warning": 2,
+		"network.bytesIn": 1000,
+		"security.SSLServerHasCertificateAuthority": 0,
+	}
+	if len(sink.gauges) != len(expected) {
+		t.Errorf("expected %d metrics, got %v", len(expected), sink.gauges)
+	}
+	for name, want := range expected {
+		if got, ok := sink.gauges[name]; !ok || got != want {
+			t.Errorf("%s: expected %d, got %d (present: %v)", name, want, got, ok)
+		}
+	}
+}
+
+func TestRawCounters(t *testing.T) {
+	for _, name := range []string{
+		"opcounters.insert",
+		"connections.totalCreated",
+		"asserts.regular",
+		"locks.Global.acquireCount.r",
+		"wiredTiger.cache.bytes_read_into_cache",
+		"opLatencies.reads.ops",
+	} {
+		if !IsCounter(name) {
+			t.Errorf("expected %s to be a counter", name)
+		}
+	}
+	for _, name := range []string{"connections.current", "wiredTiger.cache.bytes_currently_in_the_cache"} {
+		if IsCounter(name) {
+			t.Errorf("expected %s not to be a counter", name)
+		}
+	}
+}
+
+func TestRawStatsKeepDigits(t *testing.T) {
+	fields := bson.M{"wiredTiger": bson.M{"perf": bson.M{
+		"file system read latency histogram (bucket 1) - 10-49ms": 1,
+		"file system read latency histogram (bucket 2) - 50-99ms": 2,
+	}}}
+
+	sink := newRecordingSink()
+	if err := WriteRawStats(sink, fields, Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int64{
+		"wiredTiger.perf.file_system_read_latency_histogram_bucket_1_-_10-49ms": 1,
+		"wiredTiger.perf.file_system_read_latency_histogram_bucket_2_-_50-99ms": 2,
+	}
+	if len(sink.gauges) != len(expected) {
+		t.Errorf("expected %d metrics, got %v", len(expected), sink.gauges)
+	}
+	for name, want := range expected {
+		if got := sink.gauges[name]; got != want {
+			t.Errorf("%s: expected %d, got %d", name, want, got)
+		}
+	}
+}
+
+func TestRawStatsSkipTimestamps(t *testing.T) {
+	ts := bson.MongoTimestamp(1700000000<<32 | 3)
+	data, err := bson.Marshal(bson.M{
+		"uptime":        100,
+		"operationTime": ts,
+		"$clusterTime":  bson.M{"clusterTime": ts},
+		"repl":          bson.M{"lastWrite": bson.M{"opTime": bson.M{"ts": ts, "t": int64(4)}}},
+		"localTime":     time.Now(),
+		"minKey":        bson.MinKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newRecordingSink()
+	if err := WriteRawStats(sink, fields, Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int64{"uptime": 100, "repl.lastWrite.opTime.t": 4}
+	if !reflect.DeepEqual(sink.gauges, expected) {
+		t.Errorf("expected %v, got %v", expected, sink.gauges)
+	}
+}
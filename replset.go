@@ -0,0 +1,166 @@
+package mgostatsd
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// Replica set member states as reported by replSetGetStatus
+const (
+	ReplSetPrimary   = 1
+	ReplSetSecondary = 2
+	ReplSetArbiter   = 7
+)
+
+type ReplSetMember struct {
+	Name         string    `bson:"name"`
+	Health       int64     `bson:"health"`
+	State        int64     `bson:"state"`
+	StateStr     string    `bson:"stateStr"`
+	Uptime       int64     `bson:"uptime"`
+	OptimeDate   time.Time `bson:"optimeDate"`
+	PingMs       int64     `bson:"pingMs"`
+	ElectionDate time.Time `bson:"electionDate"`
+	Self         bool      `bson:"self"`
+}
+
+type ReplSetStatus struct {
+	Set     string          `bson:"set"`
+	MyState int64           `bson:"myState"`
+	Term    int64           `bson:"term"`
+	Members []ReplSetMember `bson:"members"`
+}
+
+// Primary returns the member currently in PRIMARY state, or nil if there is none
+func (s *ReplSetStatus) Primary() *ReplSetMember {
+	for i := range s.Members {
+		if s.Members[i].State == ReplSetPrimary {
+			return &s.Members[i]
+		}
+	}
+	return nil
+}
+
+// hasOptime reports whether the member replicates data and has reported its last applied
+// operation, arbiters and members in startup or recovery have no optime to compute a lag from
+func (m *ReplSetMember) hasOptime() bool {
+	return m.Health > 0 && (m.State == ReplSetPrimary || m.State == ReplSetSecondary) && !m.OptimeDate.IsZero()
+}
+
+// GetReplSetStatus returns a struct of the MongoDB 'replSetGetStatus' command response
+func GetReplSetStatus(session *mgo.Session) (*ReplSetStatus, error) {
+	var s *ReplSetStatus
+	err := session.Run("replSetGetStatus", &s)
+	return s, err
+}
+
+func pushReplSetStatus(sink Sink, status *ReplSetStatus) error {
+	var err error
+
+	err = sink.Gauge("replset.my_state", status.MyState)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge("replset.term", status.Term)
+	if err != nil {
+		return err
+	}
+
+	primary := status.Primary()
+	var healthy int64
+	for _, member := range status.Members {
+		prefix := fmt.Sprintf("replset.members.%s", metricHost(member.Name))
+		healthy += member.Health
+
+		err = sink.Gauge(prefix+".state", member.State)
+		if err != nil {
+			return err
+		}
+
+		err = sink.Gauge(prefix+".health", member.Health)
+		if err != nil {
+			return err
+		}
+
+		err = sink.Gauge(prefix+".uptime", member.Uptime)
+		if err != nil {
+			return err
+		}
+
+		if !member.Self {
+			// replSetGetStatus has no ping time for the member it was run on
+			err = sink.Gauge(prefix+".ping_ms", member.PingMs)
+			if err != nil {
+				return err
+			}
+		}
+
+		if primary != nil && member.hasOptime() {
+			lag := primary.OptimeDate.Sub(member.OptimeDate)
+			if lag < 0 {
+				lag = 0
+			}
+			err = sink.Gauge(prefix+".lag_seconds", int64(lag/time.Second))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return sink.Gauge("replset.members_healthy", healthy)
+}
+
+// ReplSetCollector emits member state, health and replication lag from replSetGetStatus,
+// and counts elections and term changes between calls
+type ReplSetCollector struct {
+	term         int64
+	electionDate time.Time
+	primary      string
+}
+
+func (c *ReplSetCollector) Collect(session *mgo.Session, sink Sink) error {
+	status, err := GetReplSetStatus(session)
+	if isCommandUnsupported(err) {
+		return nil // not a replica set member
+	}
+	if err != nil {
+		return err
+	}
+
+	err = pushReplSetStatus(sink, status)
+	if err != nil {
+		return err
+	}
+	return c.pushChanges(sink, status)
+}
+
+// pushChanges emits counters for term changes and elections seen since the previous call
+func (c *ReplSetCollector) pushChanges(sink Sink, status *ReplSetStatus) error {
+	var err error
+	first := c.term == 0 && c.electionDate.IsZero() && c.primary == ""
+
+	if !first && status.Term > c.term {
+		err = sink.Counter("replset.term_changes", status.Term-c.term)
+		if err != nil {
+			return err
+		}
+	}
+	c.term = status.Term
+
+	primary := status.Primary()
+	if primary == nil {
+		return nil // mid-election, compare against the last primary once one is elected
+	}
+	if !first && (primary.Name != c.primary || !primary.ElectionDate.Equal(c.electionDate)) {
+		err = sink.Counter("replset.elections", 1)
+		if err != nil {
+			return err
+		}
+	}
+	c.primary = primary.Name
+	c.electionDate = primary.ElectionDate
+	return nil
+}
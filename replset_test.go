@@ -0,0 +1,79 @@
+package mgostatsd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPushReplSetStatus(t *testing.T) {
+	now := time.Now()
+	status := &ReplSetStatus{
+		Set:     "rs0",
+		MyState: ReplSetSecondary,
+		Term:    3,
+		Members: []ReplSetMember{
+			{Name: "db1:27017", Health: 1, State: ReplSetPrimary, OptimeDate: now, PingMs: 2},
+			{Name: "db2:27017", Health: 1, State: ReplSetSecondary, OptimeDate: now.Add(-7 * time.Second), Self: true},
+			{Name: "db3:27017", Health: 0, State: 8},
+			{Name: "arb1:27017", Health: 1, State: ReplSetArbiter, PingMs: 1},
+		},
+	}
+
+	sink := newRecordingSink()
+	if err := pushReplSetStatus(sink, status); err != nil {
+		t.Fatalf("pushReplSetStatus failed: %v", err)
+	}
+
+	expected := map[string]int64{
+		"replset.my_state":                      ReplSetSecondary,
+		"replset.term":                          3,
+		"replset.members_healthy":               3,
+		"replset.members.db1-27017.ping_ms":     2,
+		"replset.members.db1-27017.lag_seconds": 0,
+		"replset.members.db2-27017.lag_seconds": 7,
+		"replset.members.db3-27017.state":       8,
+	}
+	for name, want := range expected {
+		if got, ok := sink.gauges[name]; !ok || got != want {
+			t.Errorf("%s: expected %d, got %d (present: %v)", name, want, got, ok)
+		}
+	}
+	if _, ok := sink.gauges["replset.members.db2-27017.ping_ms"]; ok {
+		t.Error("expected no ping time for the member the command ran on")
+	}
+	if _, ok := sink.gauges["replset.members.db3-27017.lag_seconds"]; ok {
+		t.Error("expected no lag for an unhealthy member")
+	}
+	if _, ok := sink.gauges["replset.members.arb1-27017.lag_seconds"]; ok {
+		t.Error("expected no lag for an arbiter")
+	}
+}
+
+func TestReplSetCollectorChanges(t *testing.T) {
+	elected := time.Now()
+	status := &ReplSetStatus{
+		Term: 1,
+		Members: []ReplSetMember{
+			{Name: "db1:27017", State: ReplSetPrimary, ElectionDate: elected},
+		},
+	}
+
+	collector := &ReplSetCollector{}
+	sink := newRecordingSink()
+	collector.pushChanges(sink, status)
+	if len(sink.counters) != 0 {
+		t.Errorf("expected no changes on the first sample, got %v", sink.counters)
+	}
+
+	status.Term = 2
+	status.Members[0].ElectionDate = elected.Add(time.Minute)
+	collector.pushChanges(sink, status)
+	if sink.counters["replset.term_changes"] != 1 || sink.counters["replset.elections"] != 1 {
+		t.Errorf("expected one term change and one election, got %v", sink.counters)
+	}
+
+	collector.pushChanges(sink, status)
+	if sink.counters["replset.term_changes"] != 1 || sink.counters["replset.elections"] != 1 {
+		t.Errorf("expected no further changes, got %v", sink.counters)
+	}
+}
@@ -0,0 +1,305 @@
+package mgostatsd
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// group is a target of the configuration, with its pollers and optional discovery
+type group struct {
+	config Config
+	pool   *Pool
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// Runner polls every target of a configuration, Apply switches it to a new configuration
+// restarting only what changed
+type Runner struct {
+	exporter *PrometheusExporter
+
+	// applying serializes Apply and Stop, which wait for pollers to stop. mu only guards groups,
+	// the config of every group and self, so the health pages never wait for a poller.
+	applying sync.Mutex
+	mu       sync.Mutex
+	groups   map[string]*group
+	// self writes the runtime statistics of the process with the global StatsD settings
+	self       *Outputs
+	selfConfig Statsd
+}
+
+// NewRunner creates a Runner without targets, metrics are also written to exporter unless it is nil
+func NewRunner(exporter *PrometheusExporter) *Runner {
+	return &Runner{
+		exporter: exporter,
+		groups:   make(map[string]*group),
+	}
+}
+
+// validate checks everything pollers of config would fail on
+func validate(config Config) error {
+	if _, err := NewCollectors(config); err != nil {
+		return err
+	}
+	if _, err := NewCounterTracker(config.Counters); err != nil {
+		return err
+	}
+	if err := config.Raw.Filter.Validate(); err != nil {
+		return err
+	}
+	return config.Mongo.Validate()
+}
+
+// Apply starts polling the targets of config, stopping the ones no longer configured. Targets
+// whose settings changed only restart their pollers if a setting used by them changed, new
+// sinks are swapped in without losing metrics. Nothing changes if config is invalid.
+func (r *Runner) Apply(config Config) error {
+	r.applying.Lock()
+	defer r.applying.Unlock()
+
+	targets := config.TargetConfigs()
+	wanted := make(map[string]Config, len(targets))
+	for _, target := range targets {
+		if _, ok := wanted[target.Name]; ok {
+			return fmt.Errorf("Duplicate target name %q", target.Name)
+		}
+		if err := validate(target); err != nil {
+			return fmt.Errorf("Target %s: %v", target.Name, err)
+		}
+		wanted[target.Name] = target
+	}
+
+	// open the new outputs first, so a StatsD host that doesn't resolve leaves everything running
+	var self *Outputs
+	if r.self == nil || !reflect.DeepEqual(r.selfConfig, config.Statsd) {
+		var err error
+		self, err = NewOutputs(config, nil)
+		if err != nil {
+			return err
+		}
+	}
+	outputs := make(map[string]*Outputs)
+	for name, target := range wanted {
+		if g, ok := r.groups[name]; ok && reflect.DeepEqual(g.config.Statsd, target.Statsd) {
+			continue
+		}
+		out, err := NewOutputs(target, r.exporter)
+		if err != nil {
+			for _, o := range outputs {
+				o.Close()
+			}
+			if self != nil {
+				self.Close()
+			}
+			return fmt.Errorf("Target %s: %v", name, err)
+		}
+		outputs[name] = out
+	}
+	if self != nil {
+		r.mu.Lock()
+		if r.self != nil {
+			r.self.Close()
+		}
+		r.self, r.selfConfig = self, config.Statsd
+		r.mu.Unlock()
+	}
+
+	var removed []*group
+	r.mu.Lock()
+	for name, g := range r.groups {
+		if _, ok := wanted[name]; !ok {
+			removed = append(removed, g)
+			delete(r.groups, name)
+		}
+	}
+	r.mu.Unlock()
+	for _, g := range removed {
+		g.stop()
+		log.Printf("Target %s removed\n", g.name())
+	}
+
+	names := make([]string, 0, len(wanted))
+	for name := range wanted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		target := wanted[name]
+		g, ok := r.groups[name]
+		if !ok {
+			g = &group{config: target, pool: NewPool(target, outputs[name])}
+			r.mu.Lock()
+			r.groups[name] = g
+			r.mu.Unlock()
+			log.Printf("Target %s added\n", g.name())
+			g.start()
+			continue
+		}
+		if reflect.DeepEqual(g.config, target) {
+			continue
+		}
+
+		g.stopDiscovery()
+		if out, ok := outputs[name]; ok {
+			g.pool.SetOutputs(out).Close()
+			log.Printf("Target %s sinks replaced\n", g.name())
+		}
+		if g.pool.Reconfigure(target) {
+			log.Printf("Target %s settings changed, restarting its pollers\n", g.name())
+		}
+		r.mu.Lock()
+		g.config = target
+		r.mu.Unlock()
+		g.start()
+	}
+	return nil
+}
+
+// Pools returns the pools of every target ordered by name
+func (r *Runner) Pools() []*Pool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.groups))
+	for name := range r.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pools := make([]*Pool, 0, len(names))
+	for _, name := range names {
+		pools = append(pools, r.groups[name].pool)
+	}
+	return pools
+}
+
+// Status returns the status of every poller ordered by target name and address
+func (r *Runner) Status() []PollerStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.groups))
+	for name := range r.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	statuses := []PollerStatus{}
+	for _, name := range names {
+		g := r.groups[name]
+		for _, poller := range g.pool.Pollers() {
+			status := poller.Status()
+			status.Target = g.name()
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// Ready reports whether any poller had a successful cycle within the last intervals of its polling interval
+func (r *Runner) Ready(intervals int, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, g := range r.groups {
+		window := time.Duration(intervals) * g.config.Interval
+		for _, poller := range g.pool.Pollers() {
+			if last := poller.Status().LastScrape; last != nil && now.Sub(*last) <= window {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Stop stops polling every target and closes their outputs
+func (r *Runner) Stop() {
+	r.applying.Lock()
+	defer r.applying.Unlock()
+
+	r.mu.Lock()
+	groups := r.groups
+	r.groups = make(map[string]*group)
+	self := r.self
+	r.self = nil
+	r.mu.Unlock()
+
+	for _, g := range groups {
+		g.stop()
+	}
+	if self != nil {
+		self.Close()
+	}
+}
+
+// ReportRuntime writes the runtime statistics of the process on every interval until quit is closed
+func (r *Runner) ReportRuntime(quit <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			if r.self != nil {
+				sink := r.self.RuntimeSink()
+				err := WriteRuntimeStats(sink)
+				if err == nil {
+					err = sink.Flush()
+				}
+				if err != nil {
+					log.Printf("Error writing runtime stats: %v\n", err)
+				}
+				sink.Close()
+			}
+			r.mu.Unlock()
+		case <-quit:
+			return
+		}
+	}
+}
+
+func (g *group) name() string {
+	if g.config.Name == "" {
+		return "default"
+	}
+	return g.config.Name
+}
+
+// start runs discovery, or syncs the pool with the configured addresses
+func (g *group) start() {
+	if !g.config.Discovery.Enabled {
+		added, removed, err := g.pool.Sync(AddressTargets(g.config.Mongo.Addresses))
+		if err != nil {
+			log.Printf("Target %s: error starting pollers: %v\n", g.name(), err)
+		}
+		if len(added) > 0 || len(removed) > 0 {
+			log.Printf("Target %s: added %v, removed %v\n", g.name(), added, removed)
+		}
+		return
+	}
+
+	g.quit = make(chan struct{})
+	g.done = make(chan struct{})
+	go func(config Config, quit <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+		NewDiscoverer(config.Mongo).Run(quit, config.Discovery.Interval, g.pool)
+	}(g.config, g.quit, g.done)
+}
+
+func (g *group) stopDiscovery() {
+	if g.quit == nil {
+		return
+	}
+	close(g.quit)
+	<-g.done
+	g.quit, g.done = nil, nil
+}
+
+func (g *group) stop() {
+	g.stopDiscovery()
+	g.pool.Stop()
+	g.pool.Outputs().Close()
+}
@@ -0,0 +1,108 @@
+package mgostatsd
+
+import (
+	"testing"
+	"time"
+)
+
+func testRunnerConfig(targets ...Config) Config {
+	for i := range targets {
+		targets[i].Interval = time.Second
+		targets[i].Counters = CountersGauge
+		targets[i].Reconnect = Backoff{Min: time.Hour, Max: time.Hour, Factor: 2}
+		targets[i].Mongo.Timeout = 10 * time.Millisecond
+	}
+	return Config{Targets: targets}
+}
+
+func pollersByServer(runner *Runner) map[string]*Poller {
+	pollers := make(map[string]*Poller)
+	for _, pool := range runner.Pools() {
+		for _, poller := range pool.Pollers() {
+			pollers[poller.Server] = poller
+		}
+	}
+	return pollers
+}
+
+func TestRunnerApply(t *testing.T) {
+	runner := NewRunner(nil)
+	defer runner.Stop()
+
+	err := runner.Apply(testRunnerConfig(
+		Config{Name: "a", Mongo: Mongo{Addresses: []string{"127.0.0.1:1", "127.0.0.1:2"}}},
+		Config{Name: "b", Mongo: Mongo{Addresses: []string{"127.0.0.1:3"}}},
+	))
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	before := pollersByServer(runner)
+	if len(before) != 3 {
+		t.Fatalf("expected 3 pollers, got %v", before)
+	}
+	outputsB := runner.Pools()[1].Outputs()
+
+	// a loses an address, b only changes its sink, c is new
+	err = runner.Apply(testRunnerConfig(
+		Config{Name: "a", Mongo: Mongo{Addresses: []string{"127.0.0.1:2"}}},
+		Config{Name: "b", Mongo: Mongo{Addresses: []string{"127.0.0.1:3"}}, Statsd: Statsd{Cluster: "reporting"}},
+		Config{Name: "c", Mongo: Mongo{Addresses: []string{"127.0.0.1:4"}}},
+	))
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	after := pollersByServer(runner)
+	if _, ok := after["127.0.0.1:1"]; ok || len(after) != 3 {
+		t.Errorf("unexpected pollers %v", after)
+	}
+	for _, server := range []string{"127.0.0.1:2", "127.0.0.1:3"} {
+		if after[server] != before[server] {
+			t.Errorf("%s: expected the poller to keep running", server)
+		}
+	}
+	if outputs := runner.Pools()[1].Outputs(); outputs == outputsB || outputs.statsdConfig.Cluster != "reporting" {
+		t.Error("expected the outputs of b to be replaced")
+	}
+
+	// a new interval restarts the pollers of a only
+	config := testRunnerConfig(
+		Config{Name: "a", Mongo: Mongo{Addresses: []string{"127.0.0.1:2"}}},
+		Config{Name: "b", Mongo: Mongo{Addresses: []string{"127.0.0.1:3"}}, Statsd: Statsd{Cluster: "reporting"}},
+		Config{Name: "c", Mongo: Mongo{Addresses: []string{"127.0.0.1:4"}}},
+	)
+	config.Targets[0].Interval = 2 * time.Second
+	if err := runner.Apply(config); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	restarted := pollersByServer(runner)
+	if restarted["127.0.0.1:2"] == after["127.0.0.1:2"] {
+		t.Error("expected the poller of a to be restarted")
+	}
+	if restarted["127.0.0.1:3"] != after["127.0.0.1:3"] || restarted["127.0.0.1:4"] != after["127.0.0.1:4"] {
+		t.Error("expected the pollers of b and c to keep running")
+	}
+}
+
+func TestRunnerApplyInvalid(t *testing.T) {
+	runner := NewRunner(nil)
+	defer runner.Stop()
+
+	if err := runner.Apply(testRunnerConfig(Config{Name: "a", Mongo: Mongo{Addresses: []string{"127.0.0.1:1"}}})); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	before := pollersByServer(runner)
+
+	invalid := testRunnerConfig(Config{Name: "a", Collectors: []string{"nope"}, Mongo: Mongo{Addresses: []string{"127.0.0.1:2"}}})
+	if err := runner.Apply(invalid); err == nil {
+		t.Error("expected an unknown collector to be rejected")
+	}
+	duplicate := testRunnerConfig(Config{Name: "a"}, Config{Name: "a"})
+	if err := runner.Apply(duplicate); err == nil {
+		t.Error("expected duplicate target names to be rejected")
+	}
+
+	after := pollersByServer(runner)
+	if len(after) != 1 || after["127.0.0.1:1"] != before["127.0.0.1:1"] {
+		t.Errorf("expected the running pollers to be kept, got %v", after)
+	}
+}
@@ -0,0 +1,101 @@
+package mgostatsd
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// Kinds of errors counted in the self metrics as errors.<kind>
+const (
+	ErrorConnect      = "connect"
+	ErrorServerStatus = "serverstatus"
+	ErrorWrite        = "write"
+	ErrorCollector    = "collector"
+	ErrorPush         = "push"
+)
+
+// selfSink returns a sink named below the self prefix and env, followed by path in plain StatsD
+// or tagged with tags in DogStatsD mode. Without StatsD or a self prefix it discards everything.
+// Closing it leaves the shared StatsD client of o open.
+func (o *Outputs) selfSink(path string, tags ...Tag) Sink {
+	c := o.statsdConfig
+	if o.Statsd == nil || c.SelfPrefix == "" {
+		return MultiSink(nil)
+	}
+	if c.DogStatsd {
+		tags = append([]Tag{{Key: "env", Value: c.Env}}, tags...)
+		return WithTags(NewDogStatsdSink(o.Statsd.NewSubStatter(c.SelfPrefix)), tags...)
+	}
+	return NewStatsdSink(o.Statsd.NewSubStatter(joinMetric(joinMetric(c.SelfPrefix, c.Env), path)))
+}
+
+// TargetSelfSink returns a sink for the metrics about polling target, named
+// <self prefix>.<env>.<cluster>[.<shard>].<address>
+func (o *Outputs) TargetSelfSink(target Target) Sink {
+	path := o.statsdConfig.Cluster
+	tags := []Tag{{Key: "cluster", Value: o.statsdConfig.Cluster}, {Key: "target", Value: target.Address}}
+	if target.Shard != "" {
+		path = joinMetric(path, metricComponent(target.Shard))
+		tags = append(tags, Tag{Key: "shard", Value: target.Shard})
+	}
+	return o.selfSink(joinMetric(path, metricHost(target.Address)), tags...)
+}
+
+// RuntimeSink returns a sink for the runtime statistics of this process, named
+// <self prefix>.<env>.<hostname>
+func (o *Outputs) RuntimeSink() Sink {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return o.selfSink(metricHost(hostname), Tag{Key: "host", Value: hostname})
+}
+
+// WriteRuntimeStats writes goroutine, memory and garbage collection statistics of the process to sink
+func WriteRuntimeStats(sink Sink) error {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	for _, metric := range []Metric{
+		{Name: "runtime.goroutines", Value: int64(runtime.NumGoroutine())},
+		{Name: "runtime.heap_alloc", Value: int64(m.HeapAlloc)},
+		{Name: "runtime.heap_inuse", Value: int64(m.HeapInuse)},
+		{Name: "runtime.heap_objects", Value: int64(m.HeapObjects)},
+		{Name: "runtime.sys", Value: int64(m.Sys)},
+		{Name: "runtime.gc_count", Value: int64(m.NumGC)},
+		{Name: "runtime.gc_pause_total_ms", Value: int64(time.Duration(m.PauseTotalNs) / time.Millisecond)},
+	} {
+		err := sink.Gauge(metric.Name, metric.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingSink counts the metrics written through it
+type countingSink struct {
+	Sink
+	count int64
+}
+
+func (c *countingSink) Gauge(name string, value int64, tags ...Tag) error {
+	c.count++
+	return c.Sink.Gauge(name, value, tags...)
+}
+
+func (c *countingSink) GaugeFloat(name string, value float64, tags ...Tag) error {
+	c.count++
+	return c.Sink.GaugeFloat(name, value, tags...)
+}
+
+func (c *countingSink) Counter(name string, value int64, tags ...Tag) error {
+	c.count++
+	return c.Sink.Counter(name, value, tags...)
+}
+
+func (c *countingSink) Timing(name string, value time.Duration, tags ...Tag) error {
+	c.count++
+	return c.Sink.Timing(name, value, tags...)
+}
@@ -0,0 +1,67 @@
+package mgostatsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cactus/go-statsd-client/statsd"
+)
+
+func TestTargetSelfSink(t *testing.T) {
+	sender := &packetSender{}
+	client, _ := statsd.NewClientWithSender(sender, "")
+	outputs := &Outputs{Statsd: client, statsdConfig: Statsd{Env: "prod", Cluster: "main", SelfPrefix: "mgo_statsd"}}
+
+	outputs.TargetSelfSink(Target{Address: "db1:27017"}).Gauge("metrics", 3)
+	outputs.TargetSelfSink(Target{Address: "db2:27018", Shard: "shard01"}).Counter("errors.connect", 1)
+	outputs.statsdConfig.DogStatsd = true
+	outputs.TargetSelfSink(Target{Address: "db1:27017"}).Timing("serverstatus_rtt", 12*time.Millisecond)
+	outputs.statsdConfig.SelfPrefix = ""
+	outputs.TargetSelfSink(Target{Address: "db1:27017"}).Gauge("metrics", 3)
+
+	expected := []string{
+		"mgo_statsd.prod.main.db1-27017.metrics:3|g",
+		"mgo_statsd.prod.main.shard01.db2-27018.errors.connect:1|c",
+		"mgo_statsd.serverstatus_rtt:12|ms|#env:prod,cluster:main,target:db1:27017",
+	}
+	if !reflect.DeepEqual(sender.packets, expected) {
+		t.Errorf("expected %q, got %q", expected, sender.packets)
+	}
+}
+
+func TestWriteRuntimeStats(t *testing.T) {
+	sink := newRecordingSink()
+	counted := &countingSink{Sink: sink}
+	if err := WriteRuntimeStats(counted); err != nil {
+		t.Fatalf("WriteRuntimeStats failed: %v", err)
+	}
+	if sink.gauges["runtime.goroutines"] < 1 || sink.gauges["runtime.heap_alloc"] <= 0 {
+		t.Errorf("unexpected runtime stats %v", sink.gauges)
+	}
+	if counted.count != int64(len(sink.gauges)) {
+		t.Errorf("expected %d metrics counted, got %d", len(sink.gauges), counted.count)
+	}
+}
+
+func TestSelfSinkCloseKeepsClient(t *testing.T) {
+	sender := &packetSender{}
+	client, _ := statsd.NewClientWithSender(sender, "")
+	outputs := &Outputs{Statsd: client, statsdConfig: Statsd{Env: "prod", Cluster: "main", SelfPrefix: "mgo_statsd"}}
+
+	first := outputs.TargetSelfSink(Target{Address: "db1:27017"})
+	first.Counter("errors.connect", 1)
+	first.Close()
+
+	second := outputs.TargetSelfSink(Target{Address: "db2:27017"})
+	if err := second.Gauge("metrics", 3); err != nil {
+		t.Errorf("expected a self sink to work after another was closed, got %v", err)
+	}
+	second.Close()
+	if err := WriteRuntimeStats(outputs.RuntimeSink()); err != nil {
+		t.Errorf("expected the runtime sink to work after self sinks were closed, got %v", err)
+	}
+	if sender.closed {
+		t.Error("expected closing self sinks to leave the shared client open")
+	}
+}
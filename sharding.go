@@ -0,0 +1,321 @@
+package mgostatsd
+
+import (
+	"fmt"
+	str "strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Changelog events of chunk migrations
+const (
+	migrationCommitted = "moveChunk.commit"
+	migrationFailed    = "moveChunk.error"
+)
+
+type BalancerStatus struct {
+	Mode            string `bson:"mode"`
+	InBalancerRound bool   `bson:"inBalancerRound"`
+}
+
+// Enabled reports whether the balancer may move chunks
+func (b *BalancerStatus) Enabled() bool {
+	return b.Mode != "off"
+}
+
+type balancerSettings struct {
+	Stopped bool   `bson:"stopped"`
+	Mode    string `bson:"mode"`
+}
+
+type balancerLock struct {
+	State int64 `bson:"state"`
+}
+
+// GetBalancerStatus returns the 'balancerStatus' command response, falling back to config.settings
+// and config.locks on servers without the command
+func GetBalancerStatus(session *mgo.Session) (*BalancerStatus, error) {
+	var s *BalancerStatus
+	err := session.Run("balancerStatus", &s)
+	if !isCommandUnsupported(err) {
+		return s, err
+	}
+
+	config := session.DB("config")
+	var settings balancerSettings
+	err = config.C("settings").FindId("balancer").One(&settings)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	var lock balancerLock
+	err = config.C("locks").FindId("balancer").One(&lock)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+
+	s = &BalancerStatus{Mode: "full", InBalancerRound: lock.State > 0}
+	if settings.Stopped || settings.Mode == "off" {
+		s.Mode = "off"
+	}
+	return s, nil
+}
+
+type ChunkCount struct {
+	ID struct {
+		Namespace string `bson:"ns"`
+		Shard     string `bson:"shard"`
+	} `bson:"_id"`
+	Chunks int64 `bson:"chunks"`
+	Jumbo  int64 `bson:"jumbo"`
+}
+
+// GetChunkCounts returns the number of chunks and jumbo chunks of every collection on every shard
+func GetChunkCounts(session *mgo.Session) ([]ChunkCount, error) {
+	var counts []ChunkCount
+	err := session.DB("config").C("chunks").Pipe([]bson.M{
+		{"$group": bson.M{
+			"_id":    bson.M{"ns": "$ns", "shard": "$shard"},
+			"chunks": bson.M{"$sum": 1},
+			"jumbo":  bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$eq": []interface{}{"$jumbo", true}}, 1, 0}}},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}).All(&counts)
+	return counts, err
+}
+
+type ShardingInfo struct {
+	Balancer BalancerStatus
+	Shards   []Shard
+	Chunks   []ChunkCount
+}
+
+// GetShardingInfo reads the balancer state, shards and chunk distribution of a sharded cluster
+func GetShardingInfo(session *mgo.Session) (*ShardingInfo, error) {
+	balancer, err := GetBalancerStatus(session)
+	if err != nil {
+		return nil, err
+	}
+	shards, err := GetShards(session)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := GetChunkCounts(session)
+	if err != nil {
+		return nil, err
+	}
+	return &ShardingInfo{Balancer: *balancer, Shards: shards, Chunks: chunks}, nil
+}
+
+// collectionMetric returns the metric path of a db.collection namespace as <db>.<collection>
+func collectionMetric(ns string) string {
+	parts := str.SplitN(ns, ".", 2)
+	if len(parts) == 1 {
+		return metricComponent(ns)
+	}
+	return metricComponent(parts[0]) + "." + metricComponent(parts[1])
+}
+
+func boolGauge(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func pushShardingInfo(sink Sink, info *ShardingInfo) error {
+	var err error
+
+	err = sink.Gauge("sharding.balancer.enabled", boolGauge(info.Balancer.Enabled()))
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge("sharding.balancer.running", boolGauge(info.Balancer.InBalancerRound))
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge("sharding.shards", int64(len(info.Shards)))
+	if err != nil {
+		return err
+	}
+
+	// chunks per shard of every collection, shards without chunks of a collection count as 0
+	perCollection := make(map[string]map[string]int64)
+	var namespaces []string
+	var total, jumbo int64
+	for _, count := range info.Chunks {
+		ns := count.ID.Namespace
+		if perCollection[ns] == nil {
+			perCollection[ns] = make(map[string]int64)
+			namespaces = append(namespaces, ns)
+		}
+		perCollection[ns][count.ID.Shard] += count.Chunks
+		total += count.Chunks
+		jumbo += count.Jumbo
+
+		prefix := fmt.Sprintf("sharding.coll.%s", collectionMetric(ns))
+		err = sink.Gauge(prefix+".chunks."+metricComponent(count.ID.Shard), count.Chunks)
+		if err != nil {
+			return err
+		}
+		if count.Jumbo > 0 {
+			err = sink.Gauge(prefix+".jumbo."+metricComponent(count.ID.Shard), count.Jumbo)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = sink.Gauge("sharding.chunks", total)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Gauge("sharding.jumbo_chunks", jumbo)
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range namespaces {
+		spread, imbalance := chunkImbalance(perCollection[ns], info.Shards)
+		prefix := fmt.Sprintf("sharding.coll.%s", collectionMetric(ns))
+
+		err = sink.Gauge(prefix+".chunk_spread", spread)
+		if err != nil {
+			return err
+		}
+
+		err = sink.Gauge(prefix+".imbalance_percent", imbalance)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chunkImbalance returns the difference between the most and least loaded shard, and that
+// difference as a percentage of the average chunks per shard, 0 meaning perfectly balanced
+func chunkImbalance(chunks map[string]int64, shards []Shard) (int64, int64) {
+	counts := make([]int64, 0, len(shards))
+	seen := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		seen[shard.ID] = true
+		counts = append(counts, chunks[shard.ID])
+	}
+	for shard, n := range chunks {
+		if !seen[shard] {
+			counts = append(counts, n) // a shard removed from config.shards still holding chunks
+		}
+	}
+	if len(counts) == 0 {
+		return 0, 0
+	}
+
+	min, max, sum := counts[0], counts[0], int64(0)
+	for _, n := range counts {
+		if n < min {
+			min = n
+		}
+		if n > max {
+			max = n
+		}
+		sum += n
+	}
+	if sum == 0 {
+		return 0, 0
+	}
+	return max - min, (max - min) * 100 * int64(len(counts)) / sum
+}
+
+type changelogEntry struct {
+	Time time.Time `bson:"time"`
+	What string    `bson:"what"`
+}
+
+// getMigrations returns the committed and failed chunk migrations logged in config.changelog after since
+func getMigrations(session *mgo.Session, since time.Time) ([]changelogEntry, error) {
+	var entries []changelogEntry
+	err := session.DB("config").C("changelog").Find(bson.M{
+		"what": bson.M{"$in": []string{migrationCommitted, migrationFailed}},
+		"time": bson.M{"$gt": since},
+	}).Select(bson.M{"time": 1, "what": 1}).Sort("time").All(&entries)
+	return entries, err
+}
+
+// getLastChange returns the time of the latest config.changelog entry, zero when it is empty
+func getLastChange(session *mgo.Session) (time.Time, error) {
+	var entry changelogEntry
+	err := session.DB("config").C("changelog").Find(nil).Select(bson.M{"time": 1}).Sort("-time").One(&entry)
+	if err == mgo.ErrNotFound {
+		return time.Time{}, nil
+	}
+	return entry.Time, err
+}
+
+// ShardingCollector emits the balancer state, chunk distribution and imbalance per collection
+// and counts chunk migrations between calls. It only collects on a mongos or the config server
+// primary, other members have nothing or duplicate data to report.
+type ShardingCollector struct {
+	started    bool
+	lastChange time.Time
+}
+
+func (c *ShardingCollector) Collect(session *mgo.Session, sink Sink) error {
+	isMaster, err := GetIsMaster(session)
+	if err != nil {
+		return err
+	}
+	if isMaster.Msg != "isdbgrid" && !(isMaster.ConfigSvr > 0 && isMaster.IsMaster) {
+		return nil
+	}
+
+	info, err := GetShardingInfo(session)
+	if err != nil {
+		return err
+	}
+	err = pushShardingInfo(sink, info)
+	if err != nil {
+		return err
+	}
+
+	if !c.started {
+		// only count migrations from now on, not the whole changelog on every restart
+		c.lastChange, err = getLastChange(session)
+		if err != nil {
+			return err
+		}
+		c.started = true
+		return nil
+	}
+	entries, err := getMigrations(session, c.lastChange)
+	if err != nil {
+		return err
+	}
+	return c.pushMigrations(sink, entries)
+}
+
+// pushMigrations emits counters of the committed and failed migrations in entries
+func (c *ShardingCollector) pushMigrations(sink Sink, entries []changelogEntry) error {
+	var committed, failed int64
+	for _, entry := range entries {
+		switch entry.What {
+		case migrationCommitted:
+			committed++
+		case migrationFailed:
+			failed++
+		}
+		if entry.Time.After(c.lastChange) {
+			c.lastChange = entry.Time
+		}
+	}
+
+	err := sink.Counter("sharding.migrations.committed", committed)
+	if err != nil {
+		return err
+	}
+	return sink.Counter("sharding.migrations.failed", failed)
+}
@@ -0,0 +1,86 @@
+package mgostatsd
+
+import (
+	"testing"
+	"time"
+)
+
+func chunkCount(ns, shard string, chunks, jumbo int64) ChunkCount {
+	var c ChunkCount
+	c.ID.Namespace = ns
+	c.ID.Shard = shard
+	c.Chunks = chunks
+	c.Jumbo = jumbo
+	return c
+}
+
+func TestPushShardingInfo(t *testing.T) {
+	info := &ShardingInfo{
+		Balancer: BalancerStatus{Mode: "full", InBalancerRound: true},
+		Shards:   []Shard{{ID: "shard01"}, {ID: "shard02"}, {ID: "shard03"}},
+		Chunks: []ChunkCount{
+			chunkCount("app.users", "shard01", 10, 0),
+			chunkCount("app.users", "shard02", 6, 2),
+			chunkCount("app.events.2018", "shard01", 4, 0),
+			chunkCount("app.events.2018", "shard02", 4, 0),
+			chunkCount("app.events.2018", "shard03", 4, 0),
+		},
+	}
+
+	sink := newRecordingSink()
+	if err := pushShardingInfo(sink, info); err != nil {
+		t.Fatalf("pushShardingInfo failed: %v", err)
+	}
+
+	expected := map[string]int64{
+		"sharding.balancer.enabled":                       1,
+		"sharding.balancer.running":                       1,
+		"sharding.shards":                                 3,
+		"sharding.chunks":                                 28,
+		"sharding.jumbo_chunks":                           2,
+		"sharding.coll.app.users.chunks.shard01":          10,
+		"sharding.coll.app.users.chunks.shard02":          6,
+		"sharding.coll.app.users.jumbo.shard02":           2,
+		"sharding.coll.app.users.chunk_spread":            10,
+		"sharding.coll.app.users.imbalance_percent":       187,
+		"sharding.coll.app.events_2018.chunks.shard03":    4,
+		"sharding.coll.app.events_2018.chunk_spread":      0,
+		"sharding.coll.app.events_2018.imbalance_percent": 0,
+	}
+	for name, want := range expected {
+		if got, ok := sink.gauges[name]; !ok || got != want {
+			t.Errorf("%s: expected %d, got %d", name, want, got)
+		}
+	}
+	if _, ok := sink.gauges["sharding.coll.app.users.jumbo.shard01"]; ok {
+		t.Error("expected no jumbo gauge for shards without jumbo chunks")
+	}
+
+	info.Balancer = BalancerStatus{Mode: "off"}
+	sink = newRecordingSink()
+	pushShardingInfo(sink, info)
+	if sink.gauges["sharding.balancer.enabled"] != 0 || sink.gauges["sharding.balancer.running"] != 0 {
+		t.Errorf("expected a stopped balancer, got %v", sink.gauges)
+	}
+}
+
+func TestShardingCollectorMigrations(t *testing.T) {
+	start := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := &ShardingCollector{started: true, lastChange: start}
+
+	sink := newRecordingSink()
+	err := c.pushMigrations(sink, []changelogEntry{
+		{Time: start.Add(time.Minute), What: migrationCommitted},
+		{Time: start.Add(2 * time.Minute), What: migrationFailed},
+		{Time: start.Add(3 * time.Minute), What: migrationCommitted},
+	})
+	if err != nil {
+		t.Fatalf("pushMigrations failed: %v", err)
+	}
+	if sink.counters["sharding.migrations.committed"] != 2 || sink.counters["sharding.migrations.failed"] != 1 {
+		t.Errorf("unexpected counters %v", sink.counters)
+	}
+	if !c.lastChange.Equal(start.Add(3 * time.Minute)) {
+		t.Errorf("expected the last change to advance, got %v", c.lastChange)
+	}
+}
@@ -0,0 +1,200 @@
+package mgostatsd
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	str "strings"
+	"time"
+
+	"github.com/cactus/go-statsd-client/statsd"
+)
+
+// Tag is a key/value pair attached to a metric, sinks without tag support ignore them
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Sink is a destination for metrics
+type Sink interface {
+	Gauge(name string, value int64, tags ...Tag) error
+	// GaugeFloat writes a gauge with a fractional value, such as a rate or a ratio
+	GaugeFloat(name string, value float64, tags ...Tag) error
+	Counter(name string, value int64, tags ...Tag) error
+	Timing(name string, value time.Duration, tags ...Tag) error
+	// Flush sends or publishes anything the sink buffered
+	Flush() error
+	Close() error
+}
+
+// StatsdSink adapts a statsd client to the Sink interface. Plain StatsD has no notion
+// of tags, so they are dropped unless the sink was created with NewDogStatsdSink.
+type StatsdSink struct {
+	client statsd.StatSender
+	tagged bool
+}
+
+// NewStatsdSink wraps client. Closing the sink leaves client open, so a client shared by many
+// sinks keeps working, see OwnClient for a sink closing its own client.
+func NewStatsdSink(client statsd.StatSender) *StatsdSink {
+	return &StatsdSink{client: client}
+}
+
+// NewDogStatsdSink wraps client like NewStatsdSink, sending tags in the DogStatsD "|#key:value" format
+func NewDogStatsdSink(client statsd.StatSender) *StatsdSink {
+	return &StatsdSink{client: client, tagged: true}
+}
+
+var dogStatsdEscaper = str.NewReplacer(",", "_", "|", "_", "#", "_")
+
+// dogStatsd formats value with its type suffix and tags for a raw DogStatsD submission
+func dogStatsd(value string, suffix string, tags []Tag) string {
+	var buf bytes.Buffer
+	buf.WriteString(value)
+	buf.WriteString(suffix)
+	buf.WriteString("|#")
+	for i, tag := range tags {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(dogStatsdEscaper.Replace(tag.Key))
+		buf.WriteByte(':')
+		buf.WriteString(dogStatsdEscaper.Replace(tag.Value))
+	}
+	return buf.String()
+}
+
+func (s *StatsdSink) Gauge(name string, value int64, tags ...Tag) error {
+	if s.tagged && len(tags) > 0 {
+		return s.client.Raw(name, dogStatsd(strconv.FormatInt(value, 10), "|g", tags), 1.0)
+	}
+	return s.client.Gauge(name, value, 1.0)
+}
+
+// formatFloat formats value with up to 6 decimals, without trailing zeros
+func formatFloat(value float64) string {
+	return str.TrimRight(str.TrimRight(strconv.FormatFloat(value, 'f', 6, 64), "0"), ".")
+}
+
+// GaugeFloat sends value as a raw gauge, the statsd client only formats integer ones
+func (s *StatsdSink) GaugeFloat(name string, value float64, tags ...Tag) error {
+	formatted := formatFloat(value)
+	if s.tagged && len(tags) > 0 {
+		return s.client.Raw(name, dogStatsd(formatted, "|g", tags), 1.0)
+	}
+	return s.client.Raw(name, formatted+"|g", 1.0)
+}
+
+func (s *StatsdSink) Counter(name string, value int64, tags ...Tag) error {
+	if s.tagged && len(tags) > 0 {
+		return s.client.Raw(name, dogStatsd(strconv.FormatInt(value, 10), "|c", tags), 1.0)
+	}
+	return s.client.Inc(name, value, 1.0)
+}
+
+func (s *StatsdSink) Timing(name string, value time.Duration, tags ...Tag) error {
+	if s.tagged && len(tags) > 0 {
+		return s.client.Raw(name, dogStatsd(strconv.FormatInt(int64(value/time.Millisecond), 10), "|ms", tags), 1.0)
+	}
+	return s.client.TimingDuration(name, value, 1.0)
+}
+
+func (s *StatsdSink) Flush() error {
+	return nil
+}
+
+func (s *StatsdSink) Close() error {
+	return nil
+}
+
+// OwnClient returns a Sink closing client when sink is closed, for a client used by sink only
+func OwnClient(sink Sink, client io.Closer) Sink {
+	return &owningSink{Sink: sink, client: client}
+}
+
+type owningSink struct {
+	Sink
+	client io.Closer
+}
+
+func (o *owningSink) Close() error {
+	err := o.Sink.Close()
+	if cerr := o.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WithTags returns a Sink adding tags to every metric written to sink
+func WithTags(sink Sink, tags ...Tag) Sink {
+	if len(tags) == 0 {
+		return sink
+	}
+	return &taggedSink{Sink: sink, tags: tags}
+}
+
+type taggedSink struct {
+	Sink
+	tags []Tag
+}
+
+func (t *taggedSink) with(tags []Tag) []Tag {
+	all := make([]Tag, 0, len(t.tags)+len(tags))
+	return append(append(all, t.tags...), tags...)
+}
+
+func (t *taggedSink) Gauge(name string, value int64, tags ...Tag) error {
+	return t.Sink.Gauge(name, value, t.with(tags)...)
+}
+
+func (t *taggedSink) GaugeFloat(name string, value float64, tags ...Tag) error {
+	return t.Sink.GaugeFloat(name, value, t.with(tags)...)
+}
+
+func (t *taggedSink) Counter(name string, value int64, tags ...Tag) error {
+	return t.Sink.Counter(name, value, t.with(tags)...)
+}
+
+func (t *taggedSink) Timing(name string, value time.Duration, tags ...Tag) error {
+	return t.Sink.Timing(name, value, t.with(tags)...)
+}
+
+// MultiSink fans every metric out to all of its sinks. Every sink is always
+// called, the first error encountered is returned.
+type MultiSink []Sink
+
+func (m MultiSink) each(fn func(Sink) error) error {
+	var first error
+	for _, sink := range m {
+		err := fn(sink)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m MultiSink) Gauge(name string, value int64, tags ...Tag) error {
+	return m.each(func(s Sink) error { return s.Gauge(name, value, tags...) })
+}
+
+func (m MultiSink) GaugeFloat(name string, value float64, tags ...Tag) error {
+	return m.each(func(s Sink) error { return s.GaugeFloat(name, value, tags...) })
+}
+
+func (m MultiSink) Counter(name string, value int64, tags ...Tag) error {
+	return m.each(func(s Sink) error { return s.Counter(name, value, tags...) })
+}
+
+func (m MultiSink) Timing(name string, value time.Duration, tags ...Tag) error {
+	return m.each(func(s Sink) error { return s.Timing(name, value, tags...) })
+}
+
+func (m MultiSink) Flush() error {
+	return m.each(func(s Sink) error { return s.Flush() })
+}
+
+func (m MultiSink) Close() error {
+	return m.each(func(s Sink) error { return s.Close() })
+}
@@ -0,0 +1,252 @@
+package mgostatsd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cactus/go-statsd-client/statsd"
+)
+
+// recordingSink keeps everything written to it, for testing the push helpers
+type recordingSink struct {
+	gauges   map[string]int64
+	floats   map[string]float64
+	counters map[string]int64
+	timings  map[string]time.Duration
+	tags     map[string][]Tag
+	flushed  int
+	err      error
+}
+
+func newRecordingSink() *recordingSink {
+	return &recordingSink{
+		gauges:   make(map[string]int64),
+		floats:   make(map[string]float64),
+		counters: make(map[string]int64),
+		timings:  make(map[string]time.Duration),
+		tags:     make(map[string][]Tag),
+	}
+}
+
+func (r *recordingSink) Gauge(name string, value int64, tags ...Tag) error {
+	r.gauges[name] = value
+	r.tags[name] = tags
+	return r.err
+}
+
+func (r *recordingSink) GaugeFloat(name string, value float64, tags ...Tag) error {
+	r.floats[name] = value
+	r.tags[name] = tags
+	return r.err
+}
+
+func (r *recordingSink) Counter(name string, value int64, tags ...Tag) error {
+	r.counters[name] += value
+	r.tags[name] = tags
+	return r.err
+}
+
+func (r *recordingSink) Timing(name string, value time.Duration, tags ...Tag) error {
+	r.timings[name] = value
+	r.tags[name] = tags
+	return r.err
+}
+
+func (r *recordingSink) Flush() error {
+	r.flushed++
+	return r.err
+}
+
+func (r *recordingSink) Close() error {
+	return nil
+}
+
+func TestWriteStats(t *testing.T) {
+	sink := newRecordingSink()
+	status := &ServerStatus{
+		Connections: Connections{Current: 3, Available: 97, TotalCreated: 10},
+		Opcounters:  Opcounters{Insert: 5},
+		ReplicaSet:  ReplicaInfo{IsMaster: true},
+		Asserts:     Asserts{User: 7},
+		Network: Network{
+			BytesIn:     100,
+			NumRequests: 4,
+			Compression: map[string]CompressionStats{"snappy": {Compressor: CompressionBytes{BytesIn: 60, BytesOut: 20}}},
+		},
+		WiredTiger: &WiredTigerInfo{
+			Cache: map[string]int64{"bytes currently in the cache": 1024},
+		},
+	}
+	if err := WriteStats(sink, status); err != nil {
+		t.Fatalf("WriteStats failed: %v", err)
+	}
+
+	expected := map[string]int64{
+		"connections.current":   3,
+		"connections.available": 97,
+		"connections.created":   10,
+		"ops.inserts":           5,
+		"extra.is_master":       1,
+		"extra.is_secondary":    0,
+		"wiredtiger.cache.bytes_currently_in_the_cache": 1024,
+		"asserts.user":     7,
+		"network.bytes_in": 100,
+		"network.requests": 4,
+		"network.compression.snappy.compressor.bytes_out": 20,
+	}
+	for name, want := range expected {
+		if got, ok := sink.gauges[name]; !ok || got != want {
+			t.Errorf("%s: expected %d, got %d (present: %v)", name, want, got, ok)
+		}
+	}
+}
+
+func TestMultiSink(t *testing.T) {
+	failing := newRecordingSink()
+	failing.err = errors.New("boom")
+	ok := newRecordingSink()
+	sinks := MultiSink{failing, ok}
+
+	if err := sinks.Gauge("a", 1); err == nil {
+		t.Error("expected the error of the failing sink")
+	}
+	if ok.gauges["a"] != 1 {
+		t.Error("expected every sink to receive the gauge")
+	}
+	sinks.Counter("b", 2)
+	sinks.Counter("b", 3)
+	if ok.counters["b"] != 5 {
+		t.Errorf("expected counter of 5, got %d", ok.counters["b"])
+	}
+	sinks.Flush()
+	if failing.flushed != 1 || ok.flushed != 1 {
+		t.Error("expected every sink to be flushed")
+	}
+}
+
+// packetSender records the packets a statsd client would send, and fails like a closed
+// sender once closed
+type packetSender struct {
+	packets []string
+	closed  bool
+}
+
+func (p *packetSender) Send(data []byte) (int, error) {
+	if p.closed {
+		return 0, errors.New("sender closed")
+	}
+	p.packets = append(p.packets, string(data))
+	return len(data), nil
+}
+
+func (p *packetSender) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestDogStatsdSink(t *testing.T) {
+	sender := &packetSender{}
+	client, _ := statsd.NewClientWithSender(sender, "mongodb")
+	status := &ServerStatus{
+		Host:       "db1:27017",
+		Version:    "3.6.4",
+		ReplicaSet: ReplicaInfo{SetName: "rs0", Secondary: true},
+	}
+	tags := StatusTags(Statsd{Env: "prod", Cluster: "main", Tags: []Tag{{Key: "team", Value: "a,b"}}}, status)
+	sink := WithTags(NewDogStatsdSink(client), tags...)
+
+	sink.Gauge("connections.current", 5)
+	sink.Counter("ops.inserts", 2, Tag{Key: "db", Value: "app"})
+	sink.Timing("latency", 1500*time.Millisecond)
+
+	expected := []string{
+		"mongodb.connections.current:5|g|#env:prod,cluster:main,host:db1:27017,replset:rs0,state:secondary,version:3.6.4,team:a_b",
+		"mongodb.ops.inserts:2|c|#env:prod,cluster:main,host:db1:27017,replset:rs0,state:secondary,version:3.6.4,team:a_b,db:app",
+		"mongodb.latency:1500|ms|#env:prod,cluster:main,host:db1:27017,replset:rs0,state:secondary,version:3.6.4,team:a_b",
+	}
+	if len(sender.packets) != len(expected) {
+		t.Fatalf("expected %d packets, got %v", len(expected), sender.packets)
+	}
+	for i, want := range expected {
+		if sender.packets[i] != want {
+			t.Errorf("expected %q, got %q", want, sender.packets[i])
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags([]string{"team:db", "url:http://x"})
+	if err != nil || len(tags) != 2 || tags[1].Value != "http://x" {
+		t.Errorf("unexpected result %v, %v", tags, err)
+	}
+	if _, err := ParseTags([]string{"novalue"}); err == nil {
+		t.Error("expected an error for a tag without a value")
+	}
+}
+
+func TestStatsdSinkForSharesClient(t *testing.T) {
+	sender := &packetSender{}
+	client, _ := statsd.NewClientWithSender(sender, "")
+	config := Statsd{Env: "prod", Cluster: "main"}
+
+	for _, host := range []string{"db1:27017", "db2.example.com:27018"} {
+		sink := StatsdSinkFor(client, config, &ServerStatus{Host: host})
+		if err := sink.Gauge("connections.current", 1); err != nil {
+			t.Errorf("expected the shared client to stay open, got %v", err)
+		}
+		sink.Close()
+	}
+	if sender.closed {
+		t.Error("expected closing a sink to leave the shared client open")
+	}
+
+	expected := []string{
+		"prod.main.db1-27017.connections.current:1|g",
+		"prod.main.db2_example_com-27018.connections.current:1|g",
+	}
+	if len(sender.packets) != len(expected) {
+		t.Fatalf("expected %d packets, got %v", len(expected), sender.packets)
+	}
+	for i, want := range expected {
+		if sender.packets[i] != want {
+			t.Errorf("expected %q, got %q", want, sender.packets[i])
+		}
+	}
+}
+
+func TestOwnClient(t *testing.T) {
+	sender := &packetSender{}
+	client, _ := statsd.NewClientWithSender(sender, "")
+
+	sink := OwnClient(NewStatsdSink(client), client)
+	sink.Gauge("connections.current", 1)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !sender.closed {
+		t.Error("expected the owned client to be closed with the sink")
+	}
+}
+
+func TestStatsdSinkGaugeFloat(t *testing.T) {
+	sender := &packetSender{}
+	client, _ := statsd.NewClientWithSender(sender, "mongodb")
+	NewStatsdSink(client).GaugeFloat("ops.queries", 0.05)
+	NewStatsdSink(client).GaugeFloat("ops.inserts", 1.0/3)
+	NewDogStatsdSink(client).GaugeFloat("network.bytes_in", 100, Tag{Key: "host", Value: "db1"})
+
+	expected := []string{
+		"mongodb.ops.queries:0.05|g",
+		"mongodb.ops.inserts:0.333333|g",
+		"mongodb.network.bytes_in:100|g|#host:db1",
+	}
+	if len(sender.packets) != len(expected) {
+		t.Fatalf("expected %d packets, got %v", len(expected), sender.packets)
+	}
+	for i, want := range expected {
+		if sender.packets[i] != want {
+			t.Errorf("expected %q, got %q", want, sender.packets[i])
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package mgostatsd
+
+import (
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	str "strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// ConnState is the connection state of a single monitored mongo address
+type ConnState int
+
+const (
+	// Disconnected means there is no session, either before the first dial or after a failure
+	Disconnected ConnState = iota
+	// Connecting means a dial is in progress
+	Connecting
+	// Connected means a session is established and being polled
+	Connected
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	}
+	return "unknown"
+}
+
+/* Backoff describes exponential retry delays with jitter */
+type Backoff struct {
+	Min    time.Duration
+	Max    time.Duration
+	Factor float64
+	Jitter float64
+}
+
+// DefaultBackoff is used for any Backoff field left at its zero value
+var DefaultBackoff = Backoff{
+	Min:    time.Second,
+	Max:    time.Minute,
+	Factor: 2,
+	Jitter: 0.2,
+}
+
+// Duration returns the delay before the given (zero based) retry attempt
+func (b Backoff) Duration(attempt int) time.Duration {
+	if b.Min <= 0 {
+		b.Min = DefaultBackoff.Min
+	}
+	if b.Max < b.Min {
+		b.Max = b.Min
+	}
+	if b.Factor < 1 {
+		b.Factor = DefaultBackoff.Factor
+	}
+
+	d := float64(b.Min) * math.Pow(b.Factor, float64(attempt))
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		// spread retries evenly over +/- Jitter so targets restarted together don't redial in lockstep
+		d += d * b.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// IsNetworkError reports whether err means the session to the server is no longer usable
+func IsNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	return str.HasSuffix(msg, "EOF") ||
+		str.Contains(msg, "no reachable servers") ||
+		str.Contains(msg, "Closed explicitly") ||
+		str.Contains(msg, "connection reset") ||
+		str.Contains(msg, "broken pipe")
+}
+
+// Supervisor keeps a session to a single mongo address alive, redialing with backoff
+// whenever the initial dial or a later poll fails with a network error
+type Supervisor struct {
+	Server string
+	// OnDialError, when set, is called with every failed connection attempt
+	OnDialError func(error)
+
+	mongo   Mongo
+	backoff Backoff
+
+	mu      sync.RWMutex
+	state   ConnState
+	since   time.Time
+	lastErr error
+}
+
+// NewSupervisor creates a Supervisor for server, it does not dial until Run is called
+func NewSupervisor(mongoConfig Mongo, server string, backoff Backoff) *Supervisor {
+	return &Supervisor{
+		Server:  server,
+		mongo:   mongoConfig,
+		backoff: backoff,
+		state:   Disconnected,
+		since:   time.Now(),
+	}
+}
+
+// State returns the current connection state, when it was entered and the last error seen
+func (s *Supervisor) State() (ConnState, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state, s.since, s.lastErr
+}
+
+func (s *Supervisor) setState(state ConnState, err error) {
+	s.mu.Lock()
+	prev := s.state
+	if prev != state {
+		s.since = time.Now()
+	}
+	s.state = state
+	if err != nil {
+		s.lastErr = err
+	}
+	s.mu.Unlock()
+
+	if prev != state {
+		log.Printf("Mongo %s: %v -> %v\n", s.Server, prev, state)
+	}
+}
+
+// Run dials the server and calls collect with the session on every interval until quit is closed.
+// A network error returned from collect drops the session and starts redialing.
+func (s *Supervisor) Run(quit <-chan struct{}, interval time.Duration, collect func(*mgo.Session) error) {
+	defer s.setState(Disconnected, nil)
+	for {
+		session := s.dial(quit)
+		if session == nil {
+			return
+		}
+		done := s.poll(quit, session, interval, collect)
+		session.Close()
+		if done {
+			return
+		}
+	}
+}
+
+// dial retries GetSession until it succeeds, returning nil if quit is closed first
+func (s *Supervisor) dial(quit <-chan struct{}) *mgo.Session {
+	for attempt := 0; ; attempt++ {
+		s.setState(Connecting, nil)
+		session, err := GetSession(s.mongo, s.Server)
+		if err == nil {
+			s.setState(Connected, nil)
+			return session
+		}
+		s.setState(Disconnected, err)
+		if s.OnDialError != nil {
+			s.OnDialError(err)
+		}
+
+		wait := s.backoff.Duration(attempt)
+		log.Printf("Error connecting to mongo %s: %v, retrying in %v\n", s.Server, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-quit:
+			return nil
+		}
+	}
+}
+
+// poll runs collect on every tick, returning true once quit is closed or false when the session is lost
+func (s *Supervisor) poll(quit <-chan struct{}, session *mgo.Session, interval time.Duration, collect func(*mgo.Session) error) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := collect(session)
+			if IsNetworkError(err) {
+				s.setState(Disconnected, err)
+				return false
+			}
+		case <-quit:
+			return true
+		}
+	}
+}
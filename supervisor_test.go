@@ -0,0 +1,41 @@
+package mgostatsd
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBackoffDuration(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: 30 * time.Second, Factor: 2}
+	expected := []time.Duration{1, 2, 4, 8, 16, 30, 30}
+	for attempt, want := range expected {
+		if got := b.Duration(attempt); got != want*time.Second {
+			t.Errorf("attempt %d: expected %v, got %v", attempt, want*time.Second, got)
+		}
+	}
+
+	b.Jitter = 0.5
+	for attempt := 0; attempt < 10; attempt++ {
+		got := b.Duration(attempt)
+		if got < 500*time.Millisecond || got > 45*time.Second {
+			t.Errorf("attempt %d: jittered duration %v out of range", attempt, got)
+		}
+	}
+}
+
+func TestIsNetworkError(t *testing.T) {
+	if IsNetworkError(nil) {
+		t.Error("nil is not a network error")
+	}
+	if !IsNetworkError(io.EOF) {
+		t.Error("io.EOF is a network error")
+	}
+	if !IsNetworkError(errors.New("no reachable servers")) {
+		t.Error("'no reachable servers' is a network error")
+	}
+	if IsNetworkError(errors.New("command serverStatus requires authentication")) {
+		t.Error("auth failures are not network errors")
+	}
+}
@@ -0,0 +1,113 @@
+package mgostatsd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	str "strings"
+)
+
+// MechanismX509 is the authMechanism authenticating with the TLS client certificate
+const MechanismX509 = "MONGODB-X509"
+
+// Config builds the tls.Config for mongo connections from the CA bundle, client certificate
+// and verification options. KeyFile may be left empty when CertFile holds both.
+func (t TLS) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA file %s", t.CAFile)
+		}
+	}
+
+	if t.CertFile != "" {
+		keyFile := t.KeyFile
+		if keyFile == "" {
+			keyFile = t.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// x509Username returns the subject of the client certificate in config, formatted per RFC 2253
+// as MongoDB expects for the MONGODB-X509 user
+func x509Username(config *tls.Config) (string, error) {
+	if len(config.Certificates) == 0 || len(config.Certificates[0].Certificate) == 0 {
+		return "", fmt.Errorf("%s requires a client certificate", MechanismX509)
+	}
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		return "", err
+	}
+	return rfc2253(cert.Subject.ToRDNSequence()), nil
+}
+
+var rdnNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.5":                    "SERIALNUMBER",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "STREET",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.17":                   "POSTALCODE",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+var rdnEscaper = str.NewReplacer(`\`, `\\`, `,`, `\,`, `+`, `\+`, `"`, `\"`, `<`, `\<`, `>`, `\>`, `;`, `\;`)
+
+// rfc2253 formats a distinguished name most specific component first, e.g. "CN=client,OU=ops,O=corp"
+func rfc2253(rdns pkix.RDNSequence) string {
+	var buf bytes.Buffer
+	for i := len(rdns) - 1; i >= 0; i-- {
+		if buf.Len() > 0 {
+			buf.WriteByte(',')
+		}
+		for j, atv := range rdns[i] {
+			if j > 0 {
+				buf.WriteByte('+')
+			}
+			buf.WriteString(rdnType(atv.Type))
+			buf.WriteByte('=')
+
+			value := rdnEscaper.Replace(fmt.Sprint(atv.Value))
+			if str.HasPrefix(value, " ") || str.HasPrefix(value, "#") {
+				value = `\` + value
+			}
+			if str.HasSuffix(value, " ") {
+				value = value[:len(value)-1] + `\ `
+			}
+			buf.WriteString(value)
+		}
+	}
+	return buf.String()
+}
+
+func rdnType(oid asn1.ObjectIdentifier) string {
+	if name, ok := rdnNames[oid.String()]; ok {
+		return name
+	}
+	return oid.String()
+}
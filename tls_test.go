@@ -0,0 +1,98 @@
+package mgostatsd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self signed certificate and its key as one PEM file in dir
+func writeTestCert(t *testing.T, dir string, subject pkix.Name) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "client.pem")
+	data := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTLSConfigX509Username(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mgo-statsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := writeTestCert(t, dir, pkix.Name{
+		Country:            []string{"US"},
+		Organization:       []string{"Example, Inc"},
+		OrganizationalUnit: []string{"Monitoring"},
+		CommonName:         "mgo-statsd",
+	})
+
+	config, err := TLS{Enabled: true, CAFile: certFile, CertFile: certFile, ServerName: "db.internal"}.Config()
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 || config.ServerName != "db.internal" {
+		t.Errorf("unexpected tls config %+v", config)
+	}
+
+	username, err := x509Username(config)
+	if err != nil {
+		t.Fatalf("x509Username failed: %v", err)
+	}
+	if expected := `CN=mgo-statsd,OU=Monitoring,O=Example\, Inc,C=US`; username != expected {
+		t.Errorf("expected %q, got %q", expected, username)
+	}
+
+	if _, err := (TLS{CAFile: filepath.Join(dir, "missing.pem")}).Config(); err == nil {
+		t.Error("expected an error for a missing CA file")
+	}
+}
+
+func TestMongoValidateX509(t *testing.T) {
+	if err := (Mongo{Mechanism: MechanismX509, TLS: TLS{CertFile: "client.pem"}}).Validate(); err == nil {
+		t.Error("expected MONGODB-X509 without TLS to be rejected")
+	}
+	if err := (Mongo{Mechanism: MechanismX509, TLS: TLS{Enabled: true}}).Validate(); err == nil {
+		t.Error("expected MONGODB-X509 without a client certificate to be rejected")
+	}
+	if err := (Mongo{Mechanism: MechanismX509, TLS: TLS{Enabled: true, CertFile: "client.pem"}}).Validate(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package mgostatsd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	str "strings"
+	"time"
+)
+
+const (
+	uriScheme    = "mongodb://"
+	srvURIScheme = "mongodb+srv://"
+)
+
+// DNS lookups used to expand mongodb+srv:// URIs, replaced in tests
+var (
+	lookupSRV = net.LookupSRV
+	lookupTXT = net.LookupTXT
+)
+
+// readPreferences are the valid values of the readPreference URI option
+var readPreferences = map[string]bool{
+	"primary":            true,
+	"primaryPreferred":   true,
+	"secondary":          true,
+	"secondaryPreferred": true,
+	"nearest":            true,
+}
+
+// ParseURI parses a mongodb:// or mongodb+srv:// connection string. Every host becomes one of
+// the Addresses, for mongodb+srv:// they are looked up from the DNS SRV record of the single
+// host given, with default options from its TXT record.
+func ParseURI(uri string) (Mongo, error) {
+	mongoConfig := Mongo{AuthDb: "admin"}
+
+	var rest string
+	srv := false
+	switch {
+	case str.HasPrefix(uri, srvURIScheme):
+		srv = true
+		rest = uri[len(srvURIScheme):]
+	case str.HasPrefix(uri, uriScheme):
+		rest = uri[len(uriScheme):]
+	default:
+		return mongoConfig, fmt.Errorf("Invalid mongo URI, expected it to start with %s or %s", uriScheme, srvURIScheme)
+	}
+
+	var query string
+	if i := str.Index(rest, "?"); i >= 0 {
+		rest, query = rest[:i], rest[i+1:]
+	}
+	var db string
+	if i := str.Index(rest, "/"); i >= 0 {
+		rest, db = rest[:i], rest[i+1:]
+	}
+	if i := str.LastIndex(rest, "@"); i >= 0 {
+		var err error
+		mongoConfig.User, mongoConfig.Pass, err = parseUserInfo(rest[:i])
+		if err != nil {
+			return mongoConfig, err
+		}
+		rest = rest[i+1:]
+	}
+	if rest == "" {
+		return mongoConfig, fmt.Errorf("Invalid mongo URI, no hosts given")
+	}
+	if db != "" {
+		mongoConfig.AuthDb = db
+	}
+
+	var options url.Values
+	if srv {
+		var err error
+		mongoConfig.Addresses, options, err = lookupSRVHosts(rest)
+		if err != nil {
+			return mongoConfig, err
+		}
+		mongoConfig.TLS.Enabled = true
+	} else {
+		for _, host := range str.Split(rest, ",") {
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				host = net.JoinHostPort(host, "27017")
+			}
+			mongoConfig.Addresses = append(mongoConfig.Addresses, host)
+		}
+		options = url.Values{}
+	}
+
+	// options in the URI itself take precedence over the ones from the TXT record
+	uriOptions, err := url.ParseQuery(query)
+	if err != nil {
+		return mongoConfig, fmt.Errorf("Invalid mongo URI options: %v", err)
+	}
+	for key, values := range uriOptions {
+		options[str.ToLower(key)] = values
+	}
+	return mongoConfig, applyURIOptions(&mongoConfig, options)
+}
+
+func parseUserInfo(userInfo string) (string, string, error) {
+	user := userInfo
+	pass := ""
+	if i := str.Index(userInfo, ":"); i >= 0 {
+		user, pass = userInfo[:i], userInfo[i+1:]
+	}
+	user, err := url.PathUnescape(user)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid mongo URI user: %v", err)
+	}
+	pass, err = url.PathUnescape(pass)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid mongo URI password: %v", err)
+	}
+	return user, pass, nil
+}
+
+// lookupSRVHosts resolves the hosts of a mongodb+srv:// URI and the options held in its TXT record
+func lookupSRVHosts(host string) ([]string, url.Values, error) {
+	if str.Contains(host, ",") || str.Contains(host, ":") {
+		return nil, nil, fmt.Errorf("Invalid mongo URI, %s requires a single host name without a port", srvURIScheme)
+	}
+
+	_, records, err := lookupSRV("mongodb", "tcp", host)
+	if err != nil {
+		return nil, nil, err
+	}
+	addresses := make([]string, 0, len(records))
+	for _, record := range records {
+		target := str.TrimSuffix(record.Target, ".")
+		addresses = append(addresses, net.JoinHostPort(target, strconv.Itoa(int(record.Port))))
+	}
+
+	options := url.Values{}
+	txts, err := lookupTXT(host)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && isNotFound(dnsErr) {
+			return addresses, options, nil // a TXT record is optional
+		}
+		return nil, nil, err
+	}
+	for _, txt := range txts {
+		values, err := url.ParseQuery(txt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid TXT record for %s: %v", host, err)
+		}
+		for key, v := range values {
+			options[str.ToLower(key)] = v
+		}
+	}
+	return addresses, options, nil
+}
+
+// applyURIOptions sets the options, whose names must be lower case as they are case insensitive
+func applyURIOptions(mongoConfig *Mongo, options url.Values) error {
+	for key := range options {
+		value := options.Get(key)
+		switch key {
+		case "replicaset":
+			mongoConfig.ReplicaSet = value
+		case "authsource":
+			mongoConfig.AuthDb = value
+		case "authmechanism":
+			mongoConfig.Mechanism = value
+		case "ssl", "tls":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("Invalid mongo URI option %s=%s", key, value)
+			}
+			mongoConfig.TLS.Enabled = enabled
+		case "tlscafile", "sslcafile":
+			mongoConfig.TLS.CAFile = value
+		case "tlscertificatekeyfile", "sslclientcertificatekeyfile":
+			mongoConfig.TLS.CertFile = value
+		case "tlsinsecure", "tlsallowinvalidcertificates", "sslallowinvalidcertificates":
+			insecure, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("Invalid mongo URI option %s=%s", key, value)
+			}
+			mongoConfig.TLS.InsecureSkipVerify = insecure
+		case "connecttimeoutms":
+			ms, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("Invalid mongo URI option %s=%s", key, value)
+			}
+			mongoConfig.Timeout = time.Duration(ms) * time.Millisecond
+		case "readpreference":
+			if !readPreferences[value] {
+				return fmt.Errorf("Invalid mongo URI option %s=%s", key, value)
+			}
+			mongoConfig.ReadPreference = value
+		}
+	}
+	return nil
+}